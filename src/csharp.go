@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+//
+// 生成Unity使用的C#代码. 每个表一个文件, 每行为 [Serializable] 的class, 子消息为嵌套的class,
+// 数组为 List<T>, 字典为 Dictionary<K, V>, ##desc 为注释.
+// 表为 MonsterConfigTable, 按主键查找; json 使用 Newtonsoft.Json 读取
+//
+
+type csharpGenerator struct {
+	namespace string
+}
+
+func (g *csharpGenerator) generate(schemas []*TableSchema) ([]codeFile, error) {
+	files := []codeFile{}
+	for _, schema := range schemas {
+		w := &csharpWriter{}
+		w.line("// <auto-generated>")
+		w.line("// Generated by excel2json. DO NOT EDIT.")
+		w.line("// </auto-generated>")
+		w.line("using System;")
+		w.line("using System.Collections.Generic;")
+		w.line("using Newtonsoft.Json;")
+		w.line("")
+		if g.namespace != "" {
+			w.line("namespace %v", g.namespace)
+			w.open()
+		}
+		name := exportedName(schema.Name)
+		doc := schema.Name + ".json 中的一行"
+		if schema.keyType == "" {
+			doc = schema.Name + ".json 中的配置"
+		}
+		w.class(name, schema.root, doc, func() {
+			// 全局配置没有表, 直接读取为对象
+			if schema.keyType == "" {
+				w.line("")
+				w.line("public static %v Parse(string json)", name)
+				w.open()
+				w.line("return JsonConvert.DeserializeObject<%v>(json);", name)
+				w.close()
+			}
+		})
+		if schema.keyType != "" {
+			w.line("")
+			w.table(name, csharpScalarType(schema.keyType))
+		}
+		if g.namespace != "" {
+			w.close()
+		}
+		files = append(files, codeFile{name: schema.Name + ".cs", content: w.buf.Bytes()})
+	}
+	return files, nil
+}
+
+type csharpWriter struct {
+	buf    bytes.Buffer
+	indent int
+}
+
+func (w *csharpWriter) line(format string, a ...interface{}) {
+	if format == "" {
+		w.buf.WriteString("\n")
+		return
+	}
+	w.buf.WriteString(strings.Repeat("    ", w.indent))
+	fmt.Fprintf(&w.buf, format, a...)
+	w.buf.WriteString("\n")
+}
+
+func (w *csharpWriter) open() {
+	w.line("{")
+	w.indent++
+}
+
+func (w *csharpWriter) close() {
+	w.indent--
+	w.line("}")
+}
+
+func (w *csharpWriter) summary(text string) {
+	if text == "" {
+		return
+	}
+	w.line("/// <summary>")
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		line = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(strings.TrimSpace(line))
+		w.line("/// %v", line)
+	}
+	w.line("/// </summary>")
+}
+
+// 子消息的class嵌套在使用它的class中, 名字为字段名加Data, 避免和字段重名
+func (w *csharpWriter) class(name string, node *SchemaNode, doc string, extra func()) {
+	w.summary(doc)
+	w.line("[Serializable]")
+	w.line("public class %v", name)
+	w.open()
+	type nested struct {
+		name string
+		node *SchemaNode
+		doc  string
+	}
+	subs := []nested{}
+	for i, f := range node.fields {
+		fieldName := exportedName(f.name)
+		if i > 0 && f.desc != "" {
+			w.line("")
+		}
+		w.summary(f.desc)
+		typeName := csharpTypeOf(f, fieldName+"Data", func(sub *SchemaNode, subName string) {
+			subs = append(subs, nested{name: subName, node: sub, doc: f.desc})
+		})
+		if fieldName != f.name {
+			w.line("[JsonProperty(%q)]", f.name)
+		}
+		w.line("public %v %v;", typeName, fieldName)
+	}
+	if extra != nil {
+		extra()
+	}
+	for _, sub := range subs {
+		w.line("")
+		w.class(sub.name, sub.node, sub.doc, nil)
+	}
+	w.close()
+}
+
+// 按主键查找的表
+func (w *csharpWriter) table(name, keyType string) {
+	tableName := name + "Table"
+	rows := fmt.Sprintf("Dictionary<%v, %v>", keyType, name)
+	w.summary(name + " 的表, 按主键查找")
+	w.line("public class %v", tableName)
+	w.open()
+	w.line("public readonly %v Rows;", rows)
+	w.line("")
+	w.line("public %v(%v rows)", tableName, rows)
+	w.open()
+	w.line("Rows = rows;")
+	w.close()
+	w.line("")
+	w.line("public %v Get(%v key)", name, keyType)
+	w.open()
+	w.line("%v row;", name)
+	w.line("Rows.TryGetValue(key, out row);")
+	w.line("return row;")
+	w.close()
+	w.line("")
+	w.line("public static %v Parse(string json)", tableName)
+	w.open()
+	w.line("return new %v(JsonConvert.DeserializeObject<%v>(json));", tableName, rows)
+	w.close()
+	w.close()
+}
+
+// 字段的类型, 子消息通过 nested 返回需要生成的class
+func csharpTypeOf(node *SchemaNode, name string, nested func(*SchemaNode, string)) string {
+	switch node.kind {
+	case Schema_Arr:
+		return "List<" + csharpTypeOf(node.elem, name, nested) + ">"
+	case Schema_Msg:
+		nested(node, name)
+		return name
+	case Schema_Map:
+		return fmt.Sprintf("Dictionary<%v, %v>", csharpScalarType(scalarType(node.keyType)), csharpScalarType(scalarType(node.valueType)))
+	default:
+		return csharpScalarType(scalarType(node.valueType))
+	}
+}
+
+func csharpScalarType(valueType string) string {
+	switch valueType {
+	case "int32":
+		return "int"
+	case "int64":
+		return "long"
+	default:
+		return valueType
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/laozhuzz/excel2json/validator"
+	"github.com/tealeg/xlsx/v3"
+)
+
+//
+// 枚举. 在枚举定义sheet中定义, ##type 中使用 enum<Quality>, 单元格中填写枚举名字.
+// 枚举定义sheet: sheet名为EnumConfig 或者第一个单元格为##enum, 每行为 Type | Name | Value | Desc
+// 位标记 flags<DamageType> 使用同样的定义, 单元格中填写 Fire|Ice, 导出为各值按位或的结果
+//
+
+type EnumMember struct {
+	Name  string
+	Value interface{}
+	Desc  string
+	pos   validator.CellPos
+}
+
+type EnumDesc struct {
+	Name    string
+	Members []*EnumMember
+	byName  map[string]*EnumMember
+	// 值为字符串, Value 全部为空时导出名字
+	isString bool
+}
+
+// 所有枚举定义, 转换配置sheet之前先读取
+var enums = map[string]*EnumDesc{}
+
+// flags 导出为名字数组, 否则导出为按位或的整数
+var flagsAsNames = false
+
+func isEnumSheet(sheet *xlsx.Sheet) bool {
+	if sheet.Name == "EnumConfig" {
+		return true
+	}
+	return sheet.MaxRow > 0 && sheet.MaxCol > 0 && strings.TrimSpace(getCelValue(sheet, 0, 0)) == "##enum"
+}
+
+// 类型参数, 比如 enum<Quality> 返回 enum, Quality
+func parseGenericType(valueType string) (string, string, bool) {
+	begin := strings.Index(valueType, "<")
+	if begin <= 0 || !strings.HasSuffix(valueType, ">") {
+		return valueType, "", false
+	}
+	return valueType[:begin], strings.TrimSpace(valueType[begin+1 : len(valueType)-1]), true
+}
+
+// 读取枚举定义sheet. 错误记录到errReport中, 返回非空时需要停止转换
+func readEnumSheet(filename string, sheet *xlsx.Sheet) error {
+	fmt.Printf("read enum file %v sheet %v\n", filename, sheet.Name)
+	errReport.AddSheet(filename, sheet.Name)
+	read := func(row, col int) string {
+		if col >= sheet.MaxCol {
+			return ""
+		}
+		return strings.TrimSpace(getCelValue(sheet, row, col))
+	}
+	collect := func(row, col int, format string, a ...interface{}) error {
+		return errReport.Add(filename, sheet.Name, "", &validator.CellError{
+			Pos: validator.CellPos{File: filename, Sheet: sheet.Name, Row: row, Col: col},
+			Err: fmt.Errorf(format, a...),
+		})
+	}
+
+	// 同一个sheet中的枚举, 读完后再确定值的类型
+	defined := []*EnumDesc{}
+	values := map[*EnumMember]string{}
+	for rowi := 0; rowi < sheet.MaxRow; rowi++ {
+		if strings.HasPrefix(read(rowi, 0), "##") || isCommentRow(read(rowi, 0)) {
+			continue
+		}
+		typeName, name, value := read(rowi, 1), read(rowi, 2), read(rowi, 3)
+		if typeName == "" && name == "" && value == "" {
+			continue
+		}
+		if typeName == "" || name == "" {
+			if err := collect(rowi, 1, "enum type and name should not be empty"); err != nil {
+				return err
+			}
+			continue
+		}
+		desc := enums[typeName]
+		if desc == nil {
+			desc = &EnumDesc{Name: typeName, byName: map[string]*EnumMember{}}
+			enums[typeName] = desc
+			defined = append(defined, desc)
+		} else if len(desc.Members) > 0 && (desc.Members[0].pos.File != filename || desc.Members[0].pos.Sheet != sheet.Name) {
+			// 一个枚举只能在一个sheet中定义
+			if err := collect(rowi, 1, "enum %v already defined at %v", typeName, desc.Members[0].pos); err != nil {
+				return err
+			}
+			continue
+		}
+		member := &EnumMember{
+			Name: name,
+			Desc: read(rowi, 4),
+			pos:  validator.CellPos{File: filename, Sheet: sheet.Name, Row: rowi, Col: 2},
+		}
+		if first, ok := desc.byName[name]; ok {
+			if err := collect(rowi, 2, "duplicate enum %v.%v, first defined at %v", typeName, name, first.pos); err != nil {
+				return err
+			}
+			continue
+		}
+		desc.byName[name] = member
+		desc.Members = append(desc.Members, member)
+		values[member] = value
+	}
+
+	for _, desc := range defined {
+		// 值全部为整数时导出整数, 否则导出字符串, 全部为空时导出名字
+		allEmpty, allInt := true, true
+		for _, m := range desc.Members {
+			if values[m] != "" {
+				allEmpty = false
+				if _, err := strconv.ParseInt(values[m], 10, 32); err != nil {
+					allInt = false
+				}
+			}
+		}
+		desc.isString = allEmpty || !allInt
+		seen := map[interface{}]*EnumMember{}
+		for _, m := range desc.Members {
+			v := values[m]
+			if desc.isString {
+				if v == "" {
+					v = m.Name
+				}
+				m.Value = v
+			} else {
+				if v == "" {
+					if err := collect(m.pos.Row, 3, "enum %v.%v value not set", desc.Name, m.Name); err != nil {
+						return err
+					}
+					continue
+				}
+				iv, _ := strconv.ParseInt(v, 10, 32)
+				m.Value = int32(iv)
+			}
+			if first, ok := seen[m.Value]; ok {
+				if err := collect(m.pos.Row, 3, "enum %v.%v value %v is the same as %v", desc.Name, m.Name, m.Value, first.Name); err != nil {
+					return err
+				}
+				continue
+			}
+			seen[m.Value] = m
+		}
+	}
+	return nil
+}
+
+func (e *EnumDesc) names() string {
+	names := make([]string, 0, len(e.Members))
+	for _, m := range e.Members {
+		names = append(names, m.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+// 类型是否 flags<Name>
+func isFlagsType(valueType string) bool {
+	kind, _, ok := parseGenericType(valueType)
+	return ok && kind == "flags"
+}
+
+// flags 需要整数值的枚举, 导出为名字数组时不限制
+func (e *EnumDesc) validFlags() bool {
+	return flagsAsNames || !e.isString
+}
+
+// 单元格中的 Fire|Ice 转换为按位或的整数或者名字数组, 空值为0
+func (e *EnumDesc) parseFlags(value string) (interface{}, error) {
+	names := []interface{}{}
+	var flags int32
+	seen := map[string]bool{}
+	for _, name := range strings.Split(value, "|") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			if value == "" {
+				continue
+			}
+			return nil, fmt.Errorf("empty %v flag", e.Name)
+		}
+		m, ok := e.byName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown %v %v, valid values: %v", e.Name, name, e.names())
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate %v flag %v", e.Name, name)
+		}
+		seen[name] = true
+		names = append(names, name)
+		if v, ok := m.Value.(int32); ok {
+			flags |= v
+		}
+	}
+	if flagsAsNames {
+		return names, nil
+	}
+	return flags, nil
+}
+
+// 单元格中的枚举名字转换为枚举值
+func (e *EnumDesc) parse(value string) (interface{}, error) {
+	if m, ok := e.byName[value]; ok {
+		return m.Value, nil
+	}
+	return nil, fmt.Errorf("unknown %v %v, valid values: %v", e.Name, value, e.names())
+}
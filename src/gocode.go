@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"strings"
+)
+
+//
+// 生成Go代码. 每个表一个文件, 包含每行的struct (子消息为单独命名的struct, 数组为slice, ##desc 为注释)
+// 和读取json的函数; tables.go 中的 LoadTables 读取所有表
+//
+
+type goGenerator struct {
+	pkg string
+}
+
+func (g *goGenerator) generate(schemas []*TableSchema) ([]codeFile, error) {
+	files := []codeFile{}
+	for _, schema := range schemas {
+		var buf bytes.Buffer
+		g.header(&buf, "path/filepath")
+		doc := schema.Name + ".json 中的一行"
+		if schema.keyType == "" {
+			doc = schema.Name + ".json 中的配置"
+		}
+		g.writeStruct(&buf, exportedName(schema.Name), schema.root, doc)
+		g.writeLoader(&buf, schema)
+		content, err := format.Source(buf.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("generate go code for %v. %v", schema.Name, err)
+		}
+		files = append(files, codeFile{name: schema.Name + ".go", content: content})
+	}
+
+	var buf bytes.Buffer
+	g.header(&buf, "encoding/json", "fmt", "os")
+	buf.WriteString("// Tables 所有的表\ntype Tables struct {\n")
+	for _, schema := range schemas {
+		name := exportedName(schema.Name)
+		fmt.Fprintf(&buf, "%v %v\n", name, g.tableType(schema))
+	}
+	buf.WriteString("}\n\n")
+	buf.WriteString("// LoadTables 读取 dir 下所有表的json\nfunc LoadTables(dir string) (*Tables, error) {\n")
+	buf.WriteString("tables := &Tables{}\nvar err error\n")
+	for _, schema := range schemas {
+		name := exportedName(schema.Name)
+		fmt.Fprintf(&buf, "if tables.%v, err = Load%v(dir); err != nil {\nreturn nil, err\n}\n", name, name)
+	}
+	buf.WriteString("return tables, nil\n}\n\n")
+	if int64AsString {
+		buf.WriteString(`// Int64 json 中为字符串的 int64
+type Int64 int64
+
+func (v *Int64) UnmarshalJSON(data []byte) error {
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return err
+	}
+	i, err := n.Int64()
+	*v = Int64(i)
+	return err
+}
+
+`)
+	}
+	buf.WriteString(`func loadJson(filename string, v interface{}) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("load %v. %w", filename, err)
+	}
+	return nil
+}
+`)
+	content, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("generate go code for tables. %v", err)
+	}
+	files = append(files, codeFile{name: "tables.go", content: content})
+	return files, nil
+}
+
+func (g *goGenerator) header(buf *bytes.Buffer, imports ...string) {
+	buf.WriteString("// Code generated by excel2json. DO NOT EDIT.\n\n")
+	fmt.Fprintf(buf, "package %v\n\n", g.pkg)
+	buf.WriteString("import (\n")
+	for _, v := range imports {
+		fmt.Fprintf(buf, "%q\n", v)
+	}
+	buf.WriteString(")\n\n")
+}
+
+// 表读取后的类型, 按主键的map, 竖排的全局配置为一个对象
+func (g *goGenerator) tableType(schema *TableSchema) string {
+	if schema.keyType == "" {
+		return "*" + exportedName(schema.Name)
+	}
+	return fmt.Sprintf("map[%v]*%v", goScalarType(schema.keyType), exportedName(schema.Name))
+}
+
+func (g *goGenerator) writeLoader(buf *bytes.Buffer, schema *TableSchema) {
+	name := exportedName(schema.Name)
+	tableType := g.tableType(schema)
+	fmt.Fprintf(buf, "// Load%v 读取 dir 下的 %v.json\n", name, schema.Name)
+	fmt.Fprintf(buf, "func Load%v(dir string) (%v, error) {\n", name, tableType)
+	if schema.keyType == "" {
+		fmt.Fprintf(buf, "table := &%v{}\n", name)
+	} else {
+		fmt.Fprintf(buf, "table := %v{}\n", tableType)
+	}
+	fmt.Fprintf(buf, "if err := loadJson(filepath.Join(dir, %q), ", schema.Name+".json")
+	if schema.keyType == "" {
+		buf.WriteString("table")
+	} else {
+		buf.WriteString("&table")
+	}
+	buf.WriteString("); err != nil {\nreturn nil, err\n}\nreturn table, nil\n}\n")
+}
+
+// 子消息的struct写在父struct之后, 名字为父struct名加字段名
+func (g *goGenerator) writeStruct(buf *bytes.Buffer, name string, node *SchemaNode, doc string) {
+	type nested struct {
+		name string
+		node *SchemaNode
+		doc  string
+	}
+	subs := []nested{}
+	writeComment(buf, "", strings.TrimSpace(name+" "+doc))
+	fmt.Fprintf(buf, "type %v struct {\n", name)
+	for _, f := range node.fields {
+		fieldName := exportedName(f.name)
+		writeComment(buf, "", f.desc)
+		typeName := g.typeOf(f, name+fieldName, func(sub *SchemaNode, subName string) {
+			subs = append(subs, nested{name: subName, node: sub, doc: f.desc})
+		})
+		fmt.Fprintf(buf, "%v %v `json:%q`\n", fieldName, typeName, f.name)
+	}
+	buf.WriteString("}\n\n")
+	for _, sub := range subs {
+		g.writeStruct(buf, sub.name, sub.node, sub.doc)
+	}
+}
+
+// 字段的类型, 子消息通过 nested 返回需要生成的struct
+func (g *goGenerator) typeOf(node *SchemaNode, name string, nested func(*SchemaNode, string)) string {
+	switch node.kind {
+	case Schema_Arr:
+		return "[]" + g.typeOf(node.elem, name, nested)
+	case Schema_Msg:
+		nested(node, name)
+		return "*" + name
+	case Schema_Map:
+		return fmt.Sprintf("map[%v]%v", goScalarType(scalarType(node.keyType)), g.valueType(scalarType(node.valueType)))
+	default:
+		return g.valueType(scalarType(node.valueType))
+	}
+}
+
+// 值的类型, json 中为字符串的 int64 使用生成的 Int64. 字典的key和主键总是字符串, 不需要转换
+func (g *goGenerator) valueType(valueType string) string {
+	if valueType == "int64" && int64AsString {
+		return "Int64"
+	}
+	return goScalarType(valueType)
+}
+
+func goScalarType(valueType string) string {
+	switch valueType {
+	case "float":
+		return "float32"
+	case "double":
+		return "float64"
+	default:
+		return valueType
+	}
+}
+
+// 多行的 ##desc 每行一个注释
+func writeComment(buf *bytes.Buffer, indent, text string) {
+	if text == "" {
+		return
+	}
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		fmt.Fprintf(buf, "%v// %v\n", indent, strings.TrimSpace(line))
+	}
+}
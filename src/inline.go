@@ -0,0 +1,410 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/laozhuzz/excel2json/validator"
+)
+
+//
+// 单元格中的数组和子消息.
+// 表头 Reward[{ItemId,Num}] 在一列中描述结构, ##type 为各字段的类型(按顺序逗号分隔, 只有一个时所有字段使用同一类型),
+// 单元格填 [{1001,5},{1002,3}], 和跨列的 Reward[{ItemId / Num}] 得到同样的结构.
+// 数组的数组 Grid[[]] 单元格填 [[1,2],[3,4]]. 字符串中有逗号或者括号时用双引号 "a,b",
+// 双引号中支持转义 \" \\ \n \t \r, "" 为空字符串. 普通数组 Tags[] 也使用同样的规则
+//
+
+type InlineKind uint8
+
+const (
+	Inline_Value = InlineKind(0)
+	Inline_Arr   = InlineKind(1)
+	Inline_Msg   = InlineKind(2)
+)
+
+type InlineDesc struct {
+	kind InlineKind
+	// 子消息中的字段名
+	name      string
+	valueType string
+	// 数组的元素
+	elem *InlineDesc
+	// 子消息的字段
+	fields []*InlineDesc
+}
+
+// 表头中的结构是否需要在一个单元格中填写: 括号在本列中闭合, 并且是子消息有多个字段, 或者是数组的数组.
+// 返回闭合括号的位置. Reward[] 和 Reward[{ItemId}] 仍然是原来的含义
+func inlineFieldEnd(name string, begin int) (int, bool) {
+	depth := 0
+	for i := begin; i < len(name); i++ {
+		switch name[i] {
+		case '[', '{':
+			depth++
+		case ']', '}':
+			depth--
+			if depth == 0 {
+				inner := name[begin+1 : i]
+				if strings.Contains(inner, ",") || strings.HasPrefix(strings.TrimSpace(inner), "[") {
+					return i, true
+				}
+				return 0, false
+			}
+		}
+	}
+	return 0, false
+}
+
+// 解析表头中的结构, 比如 [{ItemId,Num}], types 为按顺序的字段类型
+func parseInlineDesc(schema string, types []string) (*InlineDesc, error) {
+	p := &inlineDescParser{s: schema}
+	desc, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	if p.skipSpace(); p.pos != len(p.s) {
+		return nil, fmt.Errorf("unexpected %v in %v", p.s[p.pos:], schema)
+	}
+	if desc.kind == Inline_Value {
+		return nil, fmt.Errorf("invalid inline field %v", schema)
+	}
+	leaves := []*InlineDesc{}
+	desc.leaves(&leaves)
+	if len(types) != 1 && len(types) != len(leaves) {
+		return nil, fmt.Errorf("%v needs %v types, got %v", schema, len(leaves), len(types))
+	}
+	for i, leaf := range leaves {
+		leaf.valueType = types[0]
+		if len(types) > 1 {
+			leaf.valueType = types[i]
+		}
+		if !isValueTypeValid(leaf.valueType) {
+			return nil, errors.New("invalid valueType " + leaf.valueType)
+		}
+	}
+	return desc, nil
+}
+
+// 按逗号分隔类型, 忽略<>中的逗号, 比如 int,map<string,int>
+func splitInlineTypes(valueType string) []string {
+	types := []string{}
+	depth, start := 0, 0
+	for i := 0; i <= len(valueType); i++ {
+		if i == len(valueType) || valueType[i] == ',' && depth == 0 {
+			types = append(types, strings.TrimSpace(valueType[start:i]))
+			start = i + 1
+			continue
+		}
+		switch valueType[i] {
+		case '<':
+			depth++
+		case '>':
+			depth--
+		}
+	}
+	return types
+}
+
+func (d *InlineDesc) leaves(res *[]*InlineDesc) {
+	switch d.kind {
+	case Inline_Value:
+		*res = append(*res, d)
+	case Inline_Arr:
+		d.elem.leaves(res)
+	case Inline_Msg:
+		for _, f := range d.fields {
+			f.leaves(res)
+		}
+	}
+}
+
+type inlineDescParser struct {
+	s   string
+	pos int
+}
+
+func (p *inlineDescParser) skipSpace() {
+	for p.pos < len(p.s) && p.s[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+func (p *inlineDescParser) parse() (*InlineDesc, error) {
+	p.skipSpace()
+	if p.pos >= len(p.s) {
+		return &InlineDesc{kind: Inline_Value}, nil
+	}
+	switch p.s[p.pos] {
+	case '[':
+		p.pos++
+		p.skipSpace()
+		elem := &InlineDesc{kind: Inline_Value}
+		if p.pos < len(p.s) && p.s[p.pos] != ']' {
+			var err error
+			if elem, err = p.parse(); err != nil {
+				return nil, err
+			}
+		}
+		if p.skipSpace(); p.pos >= len(p.s) || p.s[p.pos] != ']' {
+			return nil, fmt.Errorf("mismatch [] in %v", p.s)
+		}
+		p.pos++
+		return &InlineDesc{kind: Inline_Arr, elem: elem}, nil
+	case '{':
+		p.pos++
+		desc := &InlineDesc{kind: Inline_Msg}
+		for {
+			p.skipSpace()
+			start := p.pos
+			for p.pos < len(p.s) && strings.IndexByte("[]{},", p.s[p.pos]) < 0 {
+				p.pos++
+			}
+			name := strings.TrimSpace(p.s[start:p.pos])
+			if name == "" {
+				return nil, fmt.Errorf("empty field name in %v", p.s)
+			}
+			field := &InlineDesc{kind: Inline_Value}
+			if p.pos < len(p.s) && (p.s[p.pos] == '[' || p.s[p.pos] == '{') {
+				var err error
+				if field, err = p.parse(); err != nil {
+					return nil, err
+				}
+			}
+			for _, f := range desc.fields {
+				if f.name == name {
+					return nil, fmt.Errorf("duplicate field %v in %v", name, p.s)
+				}
+			}
+			field.name = name
+			desc.fields = append(desc.fields, field)
+			if p.skipSpace(); p.pos < len(p.s) && p.s[p.pos] == ',' {
+				p.pos++
+				continue
+			}
+			if p.pos >= len(p.s) || p.s[p.pos] != '}' {
+				return nil, fmt.Errorf("mismatch {} in %v", p.s)
+			}
+			p.pos++
+			return desc, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected %v in %v", p.s[p.pos:], p.s)
+}
+
+type inlineToken struct {
+	// 括号或者逗号, 值为0
+	delim  byte
+	text   string
+	quoted bool
+	pos    int
+}
+
+// 单元格内容的分词, 值为括号和逗号之间的内容, 去掉前后空白; 以双引号开始的值为字符串, 处理转义
+type inlineLexer struct {
+	s      string
+	pos    int
+	peeked *inlineToken
+}
+
+func (l *inlineLexer) peek() (*inlineToken, error) {
+	if l.peeked != nil {
+		return l.peeked, nil
+	}
+	for l.pos < len(l.s) && (l.s[l.pos] == ' ' || l.s[l.pos] == '\t' || l.s[l.pos] == '\n' || l.s[l.pos] == '\r') {
+		l.pos++
+	}
+	if l.pos >= len(l.s) {
+		return nil, nil
+	}
+	tok := &inlineToken{pos: l.pos}
+	c := l.s[l.pos]
+	switch {
+	case strings.IndexByte("[]{},", c) >= 0:
+		tok.delim = c
+		l.pos++
+	case c == '"':
+		text, end, err := unquoteInline(l.s, l.pos)
+		if err != nil {
+			return nil, err
+		}
+		tok.text, tok.quoted = text, true
+		l.pos = end
+	default:
+		start := l.pos
+		for l.pos < len(l.s) && strings.IndexByte("[]{},", l.s[l.pos]) < 0 {
+			l.pos++
+		}
+		tok.text = strings.TrimSpace(l.s[start:l.pos])
+	}
+	l.peeked = tok
+	return tok, nil
+}
+
+func (l *inlineLexer) next() (*inlineToken, error) {
+	tok, err := l.peek()
+	l.peeked = nil
+	return tok, err
+}
+
+// 读取 s[begin] 开始的双引号字符串, 返回内容和结束位置
+func unquoteInline(s string, begin int) (string, int, error) {
+	var sb strings.Builder
+	for i := begin + 1; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return sb.String(), i + 1, nil
+		case '\\':
+			if i+1 >= len(s) {
+				return "", 0, fmt.Errorf("unterminated escape at %v", i)
+			}
+			i++
+			switch s[i] {
+			case '"', '\\':
+				sb.WriteByte(s[i])
+			case 'n':
+				sb.WriteByte('\n')
+			case 't':
+				sb.WriteByte('\t')
+			case 'r':
+				sb.WriteByte('\r')
+			default:
+				return "", 0, fmt.Errorf("unknown escape \\%c at %v", s[i], i-1)
+			}
+		default:
+			sb.WriteByte(s[i])
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string at %v", begin)
+}
+
+// 解析单元格中的值, cells 记录每个值在行中的路径
+func parseInlineCell(value string, desc *InlineDesc, path string, cells func(path string)) (interface{}, error) {
+	l := &inlineLexer{s: value}
+	v, err := parseInlineValue(l, desc, path, cells)
+	if err != nil {
+		return nil, err
+	}
+	tok, err := l.next()
+	if err != nil {
+		return nil, err
+	}
+	if tok != nil {
+		return nil, fmt.Errorf("unexpected %v at %v", tok, tok.pos)
+	}
+	return v, nil
+}
+
+func (tok *inlineToken) String() string {
+	if tok.delim != 0 {
+		return string(tok.delim)
+	}
+	return tok.text
+}
+
+// 读取一个值, 数组和子消息返回 *[]interface{} 和 map[string]interface{}, 空值返回nil
+func parseInlineValue(l *inlineLexer, desc *InlineDesc, path string, cells func(path string)) (interface{}, error) {
+	tok, err := l.peek()
+	if err != nil {
+		return nil, err
+	}
+	if tok == nil {
+		return nil, errors.New("unexpected end")
+	}
+	switch desc.kind {
+	case Inline_Arr:
+		if tok.delim != '[' {
+			return nil, fmt.Errorf("expect [ at %v", tok.pos)
+		}
+		l.next()
+		arr := []interface{}{}
+		if tok, err := l.peek(); err != nil {
+			return nil, err
+		} else if tok != nil && tok.delim == ']' {
+			l.next()
+			return &arr, nil
+		}
+		for {
+			v, err := parseInlineValue(l, desc.elem, validator.IndexPath(path, len(arr)), cells)
+			if err != nil {
+				return nil, err
+			}
+			// 数组中的空值忽略
+			if v != nil {
+				arr = append(arr, v)
+			}
+			if err := expectInlineDelim(l, ']'); err != nil {
+				return nil, err
+			}
+			if tok, _ := l.next(); tok.delim == ']' {
+				return &arr, nil
+			}
+		}
+	case Inline_Msg:
+		if tok.delim != '{' {
+			return nil, fmt.Errorf("expect { at %v", tok.pos)
+		}
+		l.next()
+		msg := map[string]interface{}{}
+		for i, field := range desc.fields {
+			if i > 0 {
+				tok, err := l.next()
+				if err != nil {
+					return nil, err
+				}
+				if tok == nil || tok.delim != ',' {
+					return nil, fmt.Errorf("message needs %v values", len(desc.fields))
+				}
+			}
+			v, err := parseInlineValue(l, field, validator.FieldPath(path, field.name), cells)
+			if err != nil {
+				return nil, err
+			}
+			if v != nil {
+				msg[field.name] = v
+			}
+		}
+		tok, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		if tok == nil || tok.delim != '}' {
+			return nil, fmt.Errorf("message needs %v values", len(desc.fields))
+		}
+		if len(msg) == 0 {
+			return nil, nil
+		}
+		return msg, nil
+	default:
+		// 空值, 比如 {1001,}
+		if tok.delim != 0 {
+			return nil, nil
+		}
+		l.next()
+		if tok.text == "" && !tok.quoted {
+			return nil, nil
+		}
+		pv, err := parseFieldValue(tok.text, desc.valueType)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %v. %v", tok.text, err)
+		}
+		cells(path)
+		return pv, nil
+	}
+}
+
+// 下一个是逗号或者指定的结束符
+func expectInlineDelim(l *inlineLexer, end byte) error {
+	tok, err := l.peek()
+	if err != nil {
+		return err
+	}
+	if tok == nil {
+		return fmt.Errorf("expect %c", end)
+	}
+	if tok.delim != ',' && tok.delim != end {
+		return fmt.Errorf("expect , or %c at %v", end, tok.pos)
+	}
+	return nil
+}
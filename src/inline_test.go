@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseInlineCell(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		types  string
+		value  string
+		want   string
+		hasErr bool
+	}{
+		{name: "plain", schema: "[]", types: "string", value: "[ a b , c ]", want: `["a b","c"]`},
+		{name: "quoted delimiters", schema: "[]", types: "string", value: `["a,b", "[x]", "{y}"]`, want: `["a,b","[x]","{y}"]`},
+		{name: "escape", schema: "[]", types: "string", value: `["say \"hi\"", "a\\b", "1\n2\t3\r"]`, want: `["say \"hi\"","a\\b","1\n2\t3\r"]`},
+		{name: "quoted empty string", schema: "[]", types: "string", value: `["", a]`, want: `["","a"]`},
+		{name: "empty array", schema: "[]", types: "int", value: "[]", want: `[]`},
+		{name: "empty element", schema: "[]", types: "int", value: "[1,,2,]", want: `[1,2]`},
+		{name: "empty message field", schema: "{ItemId,Num}", types: "int", value: "{1001,}", want: `{"ItemId":1001}`},
+		{name: "empty message", schema: "[{ItemId,Num}]", types: "int", value: "[{,},{1,2}]", want: `[{"ItemId":1,"Num":2}]`},
+		{name: "array of messages", schema: "[{ItemId,Num}]", types: "int,int64", value: "[{1001,5},{1002,3}]", want: `[{"ItemId":1001,"Num":5},{"ItemId":1002,"Num":3}]`},
+		{name: "nested array", schema: "[[]]", types: "int", value: "[[1,2],[],[3]]", want: `[[1,2],[],[3]]`},
+		{name: "array in message", schema: "[{Id,Tags[]}]", types: "int,string", value: `[{1,[a,"b,c"]}]`, want: `[{"Id":1,"Tags":["a","b,c"]}]`},
+		{name: "unbalanced open", schema: "[]", types: "int", value: "[1,2", hasErr: true},
+		{name: "unbalanced close", schema: "[]", types: "int", value: "[1,2]]", hasErr: true},
+		{name: "unbalanced nested", schema: "[[]]", types: "int", value: "[[1,2]", hasErr: true},
+		{name: "mismatched bracket", schema: "[{ItemId,Num}]", types: "int", value: "[{1,2]]", hasErr: true},
+		{name: "missing message value", schema: "{ItemId,Num}", types: "int", value: "{1}", hasErr: true},
+		{name: "unterminated string", schema: "[]", types: "string", value: `["abc]`, hasErr: true},
+		{name: "unknown escape", schema: "[]", types: "string", value: `["a\qb"]`, hasErr: true},
+		{name: "invalid value", schema: "[]", types: "int", value: "[1,x]", hasErr: true},
+	}
+	for _, tt := range tests {
+		desc, err := parseInlineDesc(tt.schema, splitInlineTypes(tt.types))
+		if err != nil {
+			t.Fatalf("%v: parseInlineDesc(%v): %v", tt.name, tt.schema, err)
+		}
+		v, err := parseInlineCell(tt.value, desc, "", func(string) {})
+		if tt.hasErr {
+			if err == nil {
+				t.Errorf("%v: parse %v: expect error, got %v", tt.name, tt.value, v)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: parse %v: %v", tt.name, tt.value, err)
+			continue
+		}
+		got, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("%v: marshal: %v", tt.name, err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%v: parse %v: got %s, want %s", tt.name, tt.value, got, tt.want)
+		}
+	}
+}
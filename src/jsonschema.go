@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/laozhuzz/excel2json/validator"
+)
+
+//
+// JSON Schema (draft 2020-12). 导出json时在同一目录写出 MonsterConfig.schema.json,
+// 描述每行的结构和类型, 可能为空的字段不在 required 中, range 规则为 minimum/maximum
+//
+
+const jsonSchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// 导出json时同时写出JSON Schema
+var exportJsonSchema = false
+
+// 按顺序输出的json对象, 字段按列顺序排列, 方便阅读
+type orderedObject []orderedField
+
+type orderedField struct {
+	key   string
+	value interface{}
+}
+
+func (o orderedObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, f := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, err := json.Marshal(f.key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(f.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func (o *orderedObject) set(key string, value interface{}) {
+	*o = append(*o, orderedField{key: key, value: value})
+}
+
+// 表的JSON Schema. 按主键的表为主键到行的对象, 竖排的全局配置为一行
+func (s *TableSchema) jsonSchema() ([]byte, error) {
+	name := exportedName(s.Name)
+	doc := orderedObject{}
+	doc.set("$schema", jsonSchemaDraft)
+	doc.set("title", s.Name)
+	if s.keyType == "" {
+		doc = append(doc, jsonSchemaOf(s.root)...)
+	} else {
+		doc.set("type", "object")
+		// json 中的主键总是字符串, 整数主键需要是数字
+		if s.keyType == "int32" || s.keyType == "int64" {
+			doc.set("propertyNames", orderedObject{{"pattern", "^-?[0-9]+$"}})
+		}
+		doc.set("additionalProperties", orderedObject{{"$ref", "#/$defs/" + name}})
+		doc.set("$defs", orderedObject{{name, jsonSchemaOf(s.root)}})
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, data, "", "  "); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+func jsonSchemaOf(node *SchemaNode) orderedObject {
+	schema := orderedObject{}
+	if node.desc != "" {
+		schema.set("description", node.desc)
+	}
+	switch node.kind {
+	case Schema_Arr:
+		schema.set("type", "array")
+		schema.set("items", jsonSchemaOf(node.elem))
+	case Schema_Msg:
+		schema.set("type", "object")
+		properties := orderedObject{}
+		required := []string{}
+		for _, f := range node.fields {
+			properties.set(f.name, jsonSchemaOf(f))
+			if f.required {
+				required = append(required, f.name)
+			}
+		}
+		schema.set("properties", properties)
+		if len(required) > 0 {
+			schema.set("required", required)
+		}
+		schema.set("additionalProperties", false)
+	case Schema_Map:
+		schema.set("type", "object")
+		value := &SchemaNode{kind: Schema_Value, valueType: node.valueType, rules: node.rules}
+		schema.set("additionalProperties", jsonSchemaOf(value))
+	default:
+		schema = append(schema, jsonSchemaValue(node.valueType, node.rules)...)
+	}
+	return schema
+}
+
+// 普通值的类型, 枚举列出所有的值, range 规则为 minimum/maximum
+func jsonSchemaValue(valueType string, rules []string) orderedObject {
+	schema := orderedObject{}
+	if scalarType(valueType) == "int64" && int64AsString {
+		// json 中为字符串, range 规则不能用 minimum/maximum 表示
+		schema.set("type", "string")
+		schema.set("pattern", "^-?[0-9]+$")
+		return schema
+	}
+	switch scalarType(valueType) {
+	case "int32":
+		fallthrough
+	case "int64":
+		schema.set("type", "integer")
+	case "float":
+		fallthrough
+	case "double":
+		schema.set("type", "number")
+	case "bool":
+		schema.set("type", "boolean")
+	default:
+		schema.set("type", "string")
+	}
+	if kind, name, ok := parseGenericType(valueType); ok && kind == "enum" && enums[name] != nil {
+		values := []interface{}{}
+		for _, m := range enums[name].Members {
+			values = append(values, m.Value)
+		}
+		schema.set("enum", values)
+	}
+	for _, rule := range rules {
+		cmd := strings.SplitN(rule, "=", 2)
+		if len(cmd) != 2 || strings.TrimSpace(cmd[0]) != "range" {
+			continue
+		}
+		if min, max, err := validator.RangeBounds(strings.TrimSpace(cmd[1])); err == nil {
+			schema.set("minimum", min)
+			schema.set("maximum", max)
+		}
+	}
+	return schema
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/tealeg/xlsx/v3"
+)
+
+//
+// 竖排的全局配置. sheet名以Const结尾, 或者第一个单元格为##layout=kv,
+// 每行为 Name | Type | Value | Desc | Validator | Target (第一列留空, Validator Target 可选), 导出为一个json对象.
+// 读取时转置为只有一行数据的表, 每个Name为一列, 和普通表使用同样的类型检查和规则检查.
+//
+
+const kvLayoutMarker = "##layout=kv"
+
+// 转置后的表头行, 以及每行在原sheet中的列
+var kvHeaders = []struct {
+	name string
+	col  int
+}{
+	{"##name", 1},
+	{"##type", 2},
+	{"##desc", 4},
+	{"##validator", 5},
+	{"##target", 6},
+}
+
+// 转置后数据行在原sheet中的列
+const kvValueCol = 3
+
+// 转置后的位置到原sheet位置的映射
+type kvLayout struct {
+	// 转置后每列在原sheet中的行
+	rows []int
+	// 转置后每行在原sheet中的列
+	cols []int
+}
+
+func isKVSheet(sheet *xlsx.Sheet) bool {
+	if strings.HasSuffix(sheet.Name, "Const") {
+		return true
+	}
+	return sheet.MaxRow > 0 && sheet.MaxCol > 0 && strings.TrimSpace(getCelValue(sheet, 0, 0)) == kvLayoutMarker
+}
+
+// 将 Name | Type | Value 的行转置为普通表的表头和一行数据
+func (t *TableData) transposeKV() {
+	layout := &kvLayout{rows: []int{0}}
+	grid := make([][]string, len(kvHeaders)+1)
+	for i, h := range kvHeaders {
+		grid[i] = []string{h.name}
+		layout.cols = append(layout.cols, h.col)
+	}
+	grid[len(kvHeaders)] = []string{""}
+	layout.cols = append(layout.cols, kvValueCol)
+
+	cell := func(row []string, col int) string {
+		if col < len(row) {
+			return row[col]
+		}
+		return ""
+	}
+	for rowi, row := range t.grid {
+		if strings.HasPrefix(strings.TrimSpace(cell(row, 0)), "##") || isCommentRow(cell(row, 0)) {
+			continue
+		}
+		blank := true
+		for _, h := range kvHeaders {
+			if strings.TrimSpace(cell(row, h.col)) != "" {
+				blank = false
+			}
+		}
+		if blank && strings.TrimSpace(cell(row, kvValueCol)) == "" {
+			continue
+		}
+		for i, h := range kvHeaders {
+			grid[i] = append(grid[i], cell(row, h.col))
+		}
+		grid[len(kvHeaders)] = append(grid[len(kvHeaders)], cell(row, kvValueCol))
+		layout.rows = append(layout.rows, rowi)
+	}
+	t.grid = grid
+	t.layout = layout
+}
+
+// 转置后的位置在原sheet中的位置
+func (l *kvLayout) cellPos(row, col int) (int, int) {
+	if row < 0 || row >= len(l.cols) || col < 0 || col >= len(l.rows) {
+		return row, col
+	}
+	return l.rows[col], l.cols[row]
+}
@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
+	"math"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -20,7 +22,7 @@ import (
 // 配置表 支持子message, 支持array
 //
 
-type TokenState uint8
+type TokenState uint16
 
 const (
 	State_None     = TokenState(0)
@@ -30,40 +32,80 @@ const (
 	State_MsgBegin = TokenState(8)
 	State_MsgEnd   = TokenState(16)
 	State_SetArr   = TokenState(32)
+	State_MapBegin = TokenState(64)
+	State_MapEnd   = TokenState(128)
+	// 一个单元格中的数组或子消息, 比如 Reward[{ItemId,Num}]
+	State_SetInline = TokenState(256)
 )
 
 type NestedFieldDesc struct {
 	name  string
 	state TokenState
+	// State_SetInline 的结构
+	inline *InlineDesc
 }
 type FieldDesc struct {
 	FieldName   string
 	ValueType   string
 	NestedField []NestedFieldDesc
+	// 导出目标, 为空时导出到所有目标
+	Targets []string
 }
 
 type RowData struct {
 	Fields []string
+	// sheet中的行号, 从0开始
+	row int
 }
 type TableData struct {
-	sheet      *xlsx.Sheet
-	header     map[string]*RowData
-	rows       []*RowData
-	rowDesc    []*FieldDesc
+	filename string
+	sheet    *xlsx.Sheet
+	// sheet中所有单元格的内容
+	grid [][]string
+	// 竖排的全局配置转置后的位置映射, 普通表为空
+	layout *kvLayout
+	header map[string]*RowData
+	// 第一行数据在sheet中的行号
+	bodyRow   int
+	rows      []*RowData
+	rowDesc   []*FieldDesc
+	keyFields []string
+	// 第一个主键列, 用于报错
+	keyColumn int
+	// 所有主键列, 主键列都为空的行为上一行的续行
+	keyColumns []int
 	parsedData map[interface{}]map[string]interface{}
-	curRow     int
-	curColumn  int
+	// 每行数据中每个值对应的单元格, 用于规则检查报错
+	sources map[interface{}]*validator.RowSource
+	// 合并进来的其他excel中的同名sheet
+	merged    []*TableData
+	curRow    int
+	curColumn int
 }
 type PostSetData struct {
 	node    interface{}
 	key     string
 	subNode interface{}
+	// node 在行中的路径
+	path string
+	// 是否有导出的列, 子列全部被目标过滤时整个array/message不导出
+	used bool
+	// 字典中已读取的列数, 列依次为 key, value, key, value...
+	mapIndex int
+	// 字典中当前的key, 类型和所在的列
+	mapKey     string
+	mapKeyType string
+	mapKeyCol  int
 }
 
+// 读取sheet, 错误记录到errReport中, 返回非空时需要停止转换
 func (t *TableData) ReadXlsxSheet() error {
-
+	t.grid = readSheetGrid(t.sheet)
+	if isKVSheet(t.sheet) {
+		t.transposeKV()
+	}
 	if err := t.readXlsxHeader(); err != nil {
-		return err
+		return t.collect(err)
 	}
 	if err := t.readXlsxBody(); err != nil {
 		return err
@@ -71,35 +113,61 @@ func (t *TableData) ReadXlsxSheet() error {
 	return nil
 }
 
-func (t *TableData) readXlsxHeader() error {
-	sheet := t.sheet
-	if sheet.MaxCol <= 1 {
-		return t.Error("empty column by" + sheet.Name)
-	}
+// 记录当前sheet的错误, 返回非空时需要停止转换
+func (t *TableData) collect(err error) error {
+	return errReport.Add(t.filename, t.sheet.Name, "", err)
+}
+
+// 读取sheet中所有单元格, 每行的列数相同.
+// MaxRow MaxCol 经常包含设置了格式但是没有内容的单元格, 末尾的空行和空列去掉
+func readSheetGrid(sheet *xlsx.Sheet) [][]string {
+	grid := make([][]string, sheet.MaxRow)
+	maxRow, maxCol := 0, 0
 	for rowi := 0; rowi < sheet.MaxRow; rowi++ {
-		curRow := make([]string, sheet.MaxCol)
+		grid[rowi] = make([]string, sheet.MaxCol)
 		for coli := 0; coli < sheet.MaxCol; coli++ {
-			value := getCelValue(sheet, rowi, coli)
-			curRow[coli] = value
+			grid[rowi][coli] = getCelValue(sheet, rowi, coli)
+			if strings.TrimSpace(grid[rowi][coli]) != "" {
+				maxRow = rowi + 1
+				if coli+1 > maxCol {
+					maxCol = coli + 1
+				}
+			}
 		}
+	}
+	grid = grid[:maxRow]
+	for rowi := range grid {
+		grid[rowi] = grid[rowi][:maxCol]
+	}
+	return grid
+}
+
+func (t *TableData) readXlsxHeader() error {
+	if len(t.grid) == 0 || len(t.grid[0]) <= 1 {
+		return t.Error("empty column")
+	}
+	for t.bodyRow = 0; t.bodyRow < len(t.grid); t.bodyRow++ {
+		curRow := t.grid[t.bodyRow]
 		if strings.HasPrefix(curRow[0], "##") {
 			t.header[curRow[0]] = &RowData{
 				Fields: curRow,
+				row:    t.bodyRow,
 			}
-		} else {
+		} else if !isCommentRow(curRow[0]) {
 			break
 		}
 	}
 	nameRow := t.header["##name"]
 	if nameRow == nil {
-		return t.Error("missed ##name row " + sheet.Name)
+		return t.Error("missed ##name row")
 	}
 	typeRow := t.header["##type"]
 	if typeRow == nil {
-		return t.Error("missed ##type row " + sheet.Name)
+		return t.Error("missed ##type row")
 	}
 	arrCharCount := 0
 	subMsgCharCount := 0
+	mapCharCount := 0
 	for i, v := range nameRow.Fields {
 		// 第一格为##name
 		if i == 0 {
@@ -108,137 +176,630 @@ func (t *TableData) readXlsxHeader() error {
 		}
 		fieldDesc := &FieldDesc{}
 		fieldDesc.FieldName = strings.TrimSpace(v)
+		// 忽略的列不检查类型, 不解析也不导出
+		if fieldDesc.ignored() {
+			t.rowDesc = append(t.rowDesc, fieldDesc)
+			continue
+		}
 		arrCharCount += strings.Count(v, "[")
 		arrCharCount -= strings.Count(v, "]")
 		subMsgCharCount += strings.Count(v, "{")
 		subMsgCharCount -= strings.Count(v, "}")
+		mapCharCount += strings.Count(v, "<")
+		mapCharCount -= strings.Count(v, ">")
 
 		valueType := typeRow.Fields[i]
-		if !isValueTypeValid(valueType) {
-			return t.Error("invalid valueType " + valueType + " in sheet " + sheet.Name)
-		}
+		t.curRow, t.curColumn = typeRow.row, i
 		fieldDesc.ValueType = valueType
 
 		if err := parseNestedFieldDesc(fieldDesc); err != nil {
-			return err
+			return t.Error(err.Error())
+		}
+		// 单元格中的结构在解析时检查各字段的类型
+		if fieldDesc.inline() == nil && !isValueTypeValid(valueType) {
+			return t.Error("invalid valueType " + valueType)
 		}
 		t.rowDesc = append(t.rowDesc, fieldDesc)
 	}
+	t.curRow, t.curColumn = nameRow.row, 0
 	if arrCharCount != 0 {
-		return t.Error("mismatch []" + " in sheet " + sheet.Name)
+		return t.Error("mismatch []")
 	}
 	if subMsgCharCount != 0 {
-		return t.Error("mismatch {}" + " in sheet " + sheet.Name)
+		return t.Error("mismatch {}")
+	}
+	if mapCharCount != 0 {
+		return t.Error("mismatch <>")
+	}
+	if err := t.checkMapFields(); err != nil {
+		return err
+	}
+	if err := t.readKeyFields(); err != nil {
+		return err
+	}
+	if targetRow := t.header["##target"]; targetRow != nil {
+		for i, v := range targetRow.Fields {
+			if i == 0 || t.rowDesc[i].ignored() {
+				continue
+			}
+			t.rowDesc[i].Targets = parseTargets(v)
+		}
 	}
 	validatorRow := t.header["##validator"]
 	if validatorRow != nil {
 		for i, v := range validatorRow.Fields {
-			if v == "" || strings.HasPrefix(v, "##") {
+			if v == "" || strings.HasPrefix(v, "##") || t.rowDesc[i].ignored() {
 				continue
 			}
 
-			src := make([]string, 0, 8)
-			src = append(src, t.sheet.Name)
-			for j := 1; j < i; j++ {
-				fieldDesc := t.rowDesc[j]
-				emptyName := 0
-				for k := 0; k < len(fieldDesc.NestedField); k++ {
-					nestFieldDesc := fieldDesc.NestedField[k]
-
-					switch nestFieldDesc.state {
-					case State_ArrBegin:
-						fallthrough
-					case State_MsgBegin:
-						if nestFieldDesc.name != "" {
-							src = append(src, nestFieldDesc.name)
-						} else {
-							emptyName++
-						}
-					case State_ArrEnd:
-						fallthrough
-					case State_MsgEnd:
-						if nestFieldDesc.name != "" {
-							src = src[0 : len(src)-1]
-						} else {
-							if emptyName > 0 {
-								emptyName--
-							} else {
-								src = src[0 : len(src)-1]
-							}
-						}
-					}
+			t.curRow, t.curColumn = validatorRow.row, i
+			src := append([]string{t.sheet.Name}, t.fieldPath(i)...)
+			cmd := strings.SplitN(v, "=", 2)
+			if len(cmd) != 2 {
+				return t.Error("validator format err")
+			}
+			// 单元格中的结构可以指定检查的字段, 比如 ItemId:ref=ItemConfig.Id
+			if pos := strings.Index(cmd[0], ":"); pos >= 0 && t.rowDesc[i].inline() != nil {
+				src = append(src, strings.Split(cmd[0][:pos], ".")...)
+				cmd[0] = cmd[0][pos+1:]
+			}
+
+			if err := validator.Instance().AddRule(strings.Join(src, "."), cmd[0], cmd[1], t.cellPos(t.curRow, i)); err != nil {
+				return t.Error(err.Error())
+			}
+		}
+	}
+
+	return nil
+}
+
+// ##name 为空或者以_开头的列忽略, 可以用来写备注
+func (desc *FieldDesc) ignored() bool {
+	return desc.FieldName == "" || strings.HasPrefix(desc.FieldName, "_")
+}
+
+// 第一列以#或者//开头的行为注释, 忽略. ##开头的为表头
+func isCommentRow(first string) bool {
+	first = strings.TrimSpace(first)
+	return (strings.HasPrefix(first, "#") && !strings.HasPrefix(first, "##")) || strings.HasPrefix(first, "//")
+}
+
+// 列中单元格内的结构, 没有时返回nil
+func (desc *FieldDesc) inline() *InlineDesc {
+	for _, v := range desc.NestedField {
+		if v.state == State_SetInline {
+			return v.inline
+		}
+	}
+	return nil
+}
+
+// 列在表中的字段路径, 用于validator规则.
+// 匿名的子消息不占路径, 数组和字典中的普通值不重复数组名, 字典的key列为 validator.MapKeyField
+func (t *TableData) fieldPath(coli int) []string {
+	stack := []NestedFieldDesc{}
+	// 每层中普通列的数量, 用于区分字典的key列和value列
+	setCount := []int{}
+	for j := 1; j < coli; j++ {
+		for _, nestFieldDesc := range t.rowDesc[j].NestedField {
+			switch nestFieldDesc.state {
+			case State_ArrBegin:
+				fallthrough
+			case State_MsgBegin:
+				fallthrough
+			case State_MapBegin:
+				stack = append(stack, nestFieldDesc)
+				setCount = append(setCount, 0)
+			case State_ArrEnd:
+				fallthrough
+			case State_MsgEnd:
+				fallthrough
+			case State_MapEnd:
+				stack = stack[:len(stack)-1]
+				setCount = setCount[:len(setCount)-1]
+			case State_Set:
+				if len(setCount) > 0 {
+					setCount[len(setCount)-1]++
 				}
 			}
-			fieldDesc := t.rowDesc[i]
-			for i := 0; i < len(fieldDesc.NestedField); i++ {
-				/*
-					if i > 0 && fieldDesc.NestedField[i].name == fieldDesc.NestedField[i-1].name &&
-						fieldDesc.NestedField[i-1].state == State_ArrBegin {
-						continue
+		}
+	}
+	for _, nestFieldDesc := range t.rowDesc[coli].NestedField {
+		if nestFieldDesc.state == State_ArrBegin || nestFieldDesc.state == State_MsgBegin || nestFieldDesc.state == State_MapBegin {
+			stack = append(stack, nestFieldDesc)
+			setCount = append(setCount, 0)
+			continue
+		}
+		if nestFieldDesc.state == State_Set && len(stack) > 0 && stack[len(stack)-1].state == State_MapBegin {
+			if setCount[len(setCount)-1]%2 == 0 {
+				stack = append(stack, NestedFieldDesc{name: validator.MapKeyField, state: State_Set})
+			}
+			break
+		}
+		if (nestFieldDesc.state == State_Set || nestFieldDesc.state == State_SetInline) && (len(stack) == 0 || stack[len(stack)-1].state != State_ArrBegin) {
+			stack = append(stack, nestFieldDesc)
+		}
+		break
+	}
+	path := make([]string, 0, len(stack))
+	for _, v := range stack {
+		if v.name != "" {
+			path = append(path, v.name)
+		}
+	}
+	return path
+}
+
+// 字典 Attr<Key, Value, Key, Value> 中只能是普通列, 依次为 key 和 value
+func (t *TableData) checkMapFields() error {
+	// 每层字典中的列数
+	stack := []int{}
+	maps := []int{}
+	for i := 1; i < len(t.rowDesc); i++ {
+		t.curColumn = i
+		for _, nestFieldDesc := range t.rowDesc[i].NestedField {
+			inMap := len(stack) > 0 && stack[len(stack)-1] >= 0
+			switch nestFieldDesc.state {
+			case State_Set:
+				if !inMap {
+					continue
+				}
+				// key列
+				if maps[len(maps)-1]%2 == 0 {
+					if kind, _, _ := parseGenericType(t.rowDesc[i].ValueType); !isKeyTypeValid(t.rowDesc[i].ValueType) && kind != "enum" {
+						return t.Error("invalid map key type " + t.rowDesc[i].ValueType)
 					}
-				*/
-				if fieldDesc.NestedField[i].name != "" {
-					src = append(src, fieldDesc.NestedField[i].name)
 				}
+				maps[len(maps)-1]++
+			case State_SetArr:
+				fallthrough
+			case State_SetInline:
+				if inMap {
+					return t.Error("map value should be a plain field")
+				}
+			case State_ArrBegin:
+				fallthrough
+			case State_MsgBegin:
+				if inMap {
+					return t.Error("map value should be a plain field")
+				}
+				stack = append(stack, -1)
+			case State_MapBegin:
+				if inMap {
+					return t.Error("map value should be a plain field")
+				}
+				maps = append(maps, 0)
+				stack = append(stack, len(maps)-1)
+			case State_ArrEnd:
+				fallthrough
+			case State_MsgEnd:
+				stack = stack[:len(stack)-1]
+			case State_MapEnd:
+				if maps[len(maps)-1]%2 != 0 || maps[len(maps)-1] == 0 {
+					return t.Error("map should have key and value columns")
+				}
+				maps = maps[:len(maps)-1]
+				stack = stack[:len(stack)-1]
 			}
-			cmd := strings.Split(v, "=")
-			if len(cmd) != 2 {
-				return t.Error("validator format err " + fieldDesc.FieldName)
+		}
+	}
+	t.curColumn = 0
+	return nil
+}
+
+// 所有列的字段路径, 用于外键指向非主键列
+func (t *TableData) columnPaths() []string {
+	paths := []string{}
+	for i := 1; i < len(t.rowDesc); i++ {
+		if t.rowDesc[i].ignored() {
+			continue
+		}
+		if path := strings.Join(t.fieldPath(i), "."); path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// 读取##key行, 标记的列共同组成主键; 没有##key行时默认为Id列.
+// 主键的类型跟随列的##type, 只支持整数和字符串
+func (t *TableData) readKeyFields() error {
+	// 竖排的全局配置没有主键, 只有一行
+	if t.layout != nil {
+		return nil
+	}
+	keyRow := t.header["##key"]
+	if keyRow == nil {
+		t.keyFields = []string{"Id"}
+		t.keyColumn = 1
+		for i, fieldDesc := range t.rowDesc {
+			if len(fieldDesc.NestedField) == 1 && fieldDesc.NestedField[0].state == State_Set && fieldDesc.NestedField[0].name == "Id" {
+				t.keyColumn = i
+				t.keyColumns = []int{i}
+				t.curRow, t.curColumn = t.header["##type"].row, i
+				if !isKeyTypeValid(fieldDesc.ValueType) {
+					return t.Error("invalid key type " + fieldDesc.ValueType)
+				}
+				break
 			}
+		}
+		return nil
+	}
+	t.curRow = keyRow.row
+	for i, v := range keyRow.Fields {
+		if i == 0 || strings.TrimSpace(v) == "" {
+			continue
+		}
+		t.curColumn = i
+		fieldDesc := t.rowDesc[i]
+		if fieldDesc.ignored() {
+			return t.Error("key column should not be ignored")
+		}
+		// 主键只能是第一层的普通字段
+		if len(fieldDesc.NestedField) != 1 || fieldDesc.NestedField[0].state != State_Set {
+			return t.Error("key column should be a plain field")
+		}
+		if !isKeyTypeValid(fieldDesc.ValueType) {
+			return t.Error("invalid key type " + fieldDesc.ValueType)
+		}
+		if len(t.keyFields) == 0 {
+			t.keyColumn = i
+		}
+		t.keyFields = append(t.keyFields, fieldDesc.NestedField[0].name)
+		t.keyColumns = append(t.keyColumns, i)
+	}
+	t.curColumn = 0
+	if len(t.keyFields) == 0 {
+		return t.Error("empty ##key row")
+	}
+	return nil
+}
 
-			if err := validator.Instance().AddRule(strings.Join(src, "."), cmd[0], cmd[1]); err != nil {
-				return t.Error(err.Error() + fieldDesc.FieldName)
+// 解析##target单元格. 逗号分隔, c 表示客户端, s 表示服务器, cs 表示两者都导出, 其他为自定义目标名
+func parseTargets(v string) []string {
+	var targets []string
+	for _, name := range strings.Split(v, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if strings.Trim(name, "cs") == "" {
+			for _, c := range name {
+				targets = append(targets, string(c))
 			}
+		} else {
+			targets = append(targets, name)
 		}
 	}
+	return targets
+}
 
+// 该列是否导出到目标, target为空时导出所有列, 主键列总是导出
+func (t *TableData) isColumnExported(coli int, target string) bool {
+	desc := t.rowDesc[coli]
+	if target == "" || len(desc.Targets) == 0 {
+		return true
+	}
+	if len(desc.NestedField) == 1 && desc.NestedField[0].state == State_Set {
+		for _, k := range t.keyFields {
+			if desc.NestedField[0].name == k {
+				return true
+			}
+		}
+	}
+	for _, v := range desc.Targets {
+		if v == target {
+			return true
+		}
+	}
+	return false
+}
+
+// 行主键. 单主键为字段值本身, 多主键拼接为字符串, 没有主键时为0
+func (t *TableData) rowKey(r map[string]interface{}) interface{} {
+	if len(t.keyFields) == 0 {
+		return int32(0)
+	}
+	if len(t.keyFields) == 1 {
+		return r[t.keyFields[0]]
+	}
+	values := make([]interface{}, 0, len(t.keyFields))
+	for _, k := range t.keyFields {
+		values = append(values, r[k])
+	}
+	return validator.JoinKey(values...)
+}
+
+// 多主键拼接后要能区分各列的值, 字符串主键中不能包含分隔符, 比如 ("a|b","c") 和 ("a","b|c")
+func (t *TableData) checkKeyValues(k int, r map[string]interface{}) error {
+	if len(t.keyFields) < 2 {
+		return nil
+	}
+	for i, name := range t.keyFields {
+		if s, ok := r[name].(string); ok && strings.Contains(s, validator.KeySeparator) {
+			t.curRow, t.curColumn = t.rows[k].row, t.keyColumns[i]
+			return t.Error("composite key value %v should not contain %v", s, validator.KeySeparator)
+		}
+	}
 	return nil
 }
 
 func (t *TableData) readXlsxBody() error {
-	sheet := t.sheet
-	t.rows = make([]*RowData, 0, t.sheet.MaxRow)
+	t.rows = make([]*RowData, 0, len(t.grid))
 
-	for rowi := len(t.header); rowi < sheet.MaxRow; rowi++ {
-		curRow := make([]string, sheet.MaxCol)
-		for coli := 0; coli < sheet.MaxCol; coli++ {
-			value := getCelValue(sheet, rowi, coli)
+	for rowi := t.bodyRow; rowi < len(t.grid); rowi++ {
+		curRow := make([]string, len(t.grid[rowi]))
+		for coli, value := range t.grid[rowi] {
 			// 移除前后空白
 			curRow[coli] = strings.TrimSpace(value)
 		}
 		if strings.HasPrefix(curRow[0], "##") {
-			return t.Error("desc row " + curRow[0] + " should be the top of a sheet " + sheet.Name)
+			t.curRow, t.curColumn = rowi, 0
+			if err := t.collect(t.Error("desc row " + curRow[0] + " should be the top of a sheet")); err != nil {
+				return err
+			}
+			continue
+		}
+		if isCommentRow(curRow[0]) {
+			continue
+		}
+		// 数据中间的空行忽略并给出警告, 末尾的空行已经去掉
+		if t.isBlankRow(curRow) {
+			errReport.Warn(t.filename, t.sheet.Name, &validator.CellError{
+				Pos: t.cellPos(rowi, 0),
+				Err: errors.New("blank row skipped"),
+			})
+			continue
 		}
-		t.rows = append(t.rows, &RowData{Fields: curRow})
+		t.rows = append(t.rows, &RowData{Fields: curRow, row: rowi})
 	}
-	if err := t.parseTableData(); err != nil {
+	parsedData, sources, err := t.parseTableData("")
+	if err != nil {
 		return err
 	}
+	t.parsedData = parsedData
+	t.sources = sources
 	return nil
 }
 
-func (t *TableData) parseTableData() error {
-	if t.parsedData == nil {
-		t.parsedData = make(map[interface{}]map[string]interface{})
-	}
+// 解析所有数据行, target不为空时只包含导出到该目标的列. 同时返回每行中每个值对应的单元格.
+// 出错的行记录到errReport中并跳过, 返回非空时需要停止转换
+func (t *TableData) parseTableData(target string) (map[interface{}]map[string]interface{}, map[interface{}]*validator.RowSource, error) {
+	parsedData := make(map[interface{}]map[string]interface{})
+	sources := make(map[interface{}]*validator.RowSource)
+	// 上一条记录, 续行中的数组追加到上一条记录中. 上一行出错时为空
+	var prev map[string]interface{}
+	var prevCells map[string]validator.CellPos
 	for k := range t.rows {
-		if r, err := t.parseRowData(k); err != nil {
-			return err
+		if t.isContinuationRow(t.rows[k]) {
+			if prev == nil {
+				// 上一行出错时已经报过错
+				if k == 0 {
+					t.curRow, t.curColumn = t.rows[k].row, t.keyColumn
+					if err := t.collect(t.Error("continuation row without a record, key not set")); err != nil {
+						return nil, nil, err
+					}
+				}
+				continue
+			}
+			if _, _, err := t.parseRowData(k, target, prev, prevCells); err != nil {
+				if err := t.collect(err); err != nil {
+					return nil, nil, err
+				}
+			}
+			continue
+		}
+		prev, prevCells = nil, nil
+		if r, cells, err := t.parseRowData(k, target, nil, nil); err != nil {
+			if err := t.collect(err); err != nil {
+				return nil, nil, err
+			}
+		} else if err := t.checkKeyValues(k, r); err != nil {
+			if err := t.collect(err); err != nil {
+				return nil, nil, err
+			}
 		} else {
-			t.parsedData[r["Id"].(int32)] = r
+			prev, prevCells = r, cells
+			key := t.rowKey(r)
+			src := &validator.RowSource{
+				Pos:   t.cellPos(t.rows[k].row, t.keyColumn),
+				Cells: cells,
+			}
+			if first, ok := sources[key]; ok {
+				// 按目标导出时重复已经在完整解析时报过
+				replace := overrideDuplicate
+				if target == "" {
+					var err error
+					if replace, err = duplicateKey(key, first.Pos, src.Pos); err != nil {
+						return nil, nil, err
+					}
+				}
+				if !replace {
+					continue
+				}
+			}
+			parsedData[key] = r
+			sources[key] = src
 		}
 	}
 
+	return parsedData, sources, nil
+}
+
+// 主键重复时后面的行是否覆盖前面的行, 否则报错
+var overrideDuplicate = false
+
+// 主键重复, 默认报错并保留先出现的行; overrideDuplicate 时后出现的行覆盖先出现的行并给出警告.
+// 返回是否覆盖, 错误非空时需要停止转换
+func duplicateKey(key interface{}, first, second validator.CellPos) (bool, error) {
+	err := &validator.CellError{
+		Pos: second,
+		Err: fmt.Errorf("duplicate key %v, first defined at %v", key, first),
+	}
+	if overrideDuplicate {
+		errReport.Warn(second.File, second.Sheet, err)
+		return true, nil
+	}
+	return false, errReport.Add(second.File, second.Sheet, "", err)
+}
+
+// 合并其他excel中的同名sheet, 表头必须一致. 错误记录到errReport中, 返回非空时需要停止转换
+func (t *TableData) merge(other *TableData) error {
+	same := len(t.rowDesc) == len(other.rowDesc) && strings.Join(t.keyFields, ",") == strings.Join(other.keyFields, ",")
+	for i := 0; same && i < len(t.rowDesc); i++ {
+		same = t.rowDesc[i].FieldName == other.rowDesc[i].FieldName && t.rowDesc[i].ValueType == other.rowDesc[i].ValueType
+	}
+	if !same {
+		other.curRow, other.curColumn = other.header["##name"].row, 0
+		return other.collect(other.Error("header is different from sheet %v in %v", t.sheet.Name, t.filename))
+	}
+	keys := make([]interface{}, 0, len(other.parsedData))
+	for key := range other.parsedData {
+		keys = append(keys, key)
+	}
+	// 按行号顺序合并, 保证报错顺序稳定
+	sort.Slice(keys, func(i, j int) bool {
+		return other.sources[keys[i]].Pos.Row < other.sources[keys[j]].Pos.Row
+	})
+	for _, key := range keys {
+		r := other.parsedData[key]
+		src := other.sources[key]
+		if first, ok := t.sources[key]; ok {
+			replace, err := duplicateKey(key, first.Pos, src.Pos)
+			if err != nil {
+				return err
+			}
+			if !replace {
+				continue
+			}
+		}
+		t.parsedData[key] = r
+		t.sources[key] = src
+	}
+	t.merged = append(t.merged, other)
 	return nil
 }
 
-func (t *TableData) parseRowData(rowi int) (map[string]interface{}, error) {
+// 导出到目标的数据, 包括合并进来的同名sheet
+func (t *TableData) exportData(target string) (map[interface{}]map[string]interface{}, error) {
+	if target == "" {
+		return t.parsedData, nil
+	}
+	parsedData, _, err := t.parseTableData(target)
+	if err != nil {
+		return nil, err
+	}
+	for _, other := range t.merged {
+		otherData, _, err := other.parseTableData(target)
+		if err != nil {
+			return nil, err
+		}
+		for key, r := range otherData {
+			if _, ok := parsedData[key]; ok && !overrideDuplicate {
+				continue
+			}
+			parsedData[key] = r
+		}
+	}
+	return parsedData, nil
+}
+
+// 单元格位置, 竖排的全局配置转换为原sheet中的位置
+func (t *TableData) cellPos(row, col int) validator.CellPos {
+	if t.layout != nil {
+		row, col = t.layout.cellPos(row, col)
+	}
+	return validator.CellPos{
+		File:  t.filename,
+		Sheet: t.sheet.Name,
+		Row:   row,
+		Col:   col,
+	}
+}
+
+// 空行: 除了忽略的列都为空
+func (t *TableData) isBlankRow(fields []string) bool {
+	for i, v := range fields {
+		if v != "" && (i == 0 || !t.rowDesc[i].ignored()) {
+			return false
+		}
+	}
+	return true
+}
+
+// 续行: 主键列都为空, 其他列不全为空. 续行中只能填数组中的值, 追加到上一条记录的数组中
+func (t *TableData) isContinuationRow(row *RowData) bool {
+	if len(t.keyColumns) == 0 {
+		return false
+	}
+	for _, i := range t.keyColumns {
+		if row.Fields[i] != "" {
+			return false
+		}
+	}
+	for i, v := range row.Fields {
+		if i > 0 && v != "" && !t.rowDesc[i].ignored() {
+			return true
+		}
+	}
+	return false
+}
+
+// 续行中数组下标的偏移, 比如 path 为 Reward, offset 为2时 Reward[0].ItemId 改为 Reward[2].ItemId
+func shiftIndexPath(p, path string, offset int) string {
+	prefix := path + "["
+	if !strings.HasPrefix(p, prefix) {
+		return p
+	}
+	end := strings.Index(p[len(prefix):], "]")
+	if end < 0 {
+		return p
+	}
+	i, err := strconv.Atoi(p[len(prefix) : len(prefix)+end])
+	if err != nil {
+		return p
+	}
+	return validator.IndexPath(path, i+offset) + p[len(prefix)+end+1:]
+}
+
+// 解析一行数据. prev 不为空时为续行, 数组中的值追加到 prev 中, 单元格记录到 prevCells 中
+func (t *TableData) parseRowData(rowi int, target string, prev map[string]interface{}, prevCells map[string]validator.CellPos) (map[string]interface{}, map[string]validator.CellPos, error) {
 	row := t.rows[rowi]
 	parsed := map[string]interface{}{}
+	cells := map[string]validator.CellPos{}
+	continuation := prev != nil
+	if continuation {
+		parsed, cells = prev, prevCells
+	}
 	objStack := []*PostSetData{}
 	var curObj interface{}
+	// 是否在数组或者字典中, 续行中只能填数组或者字典中的值
+	inArray := func() bool {
+		for _, pdata := range objStack {
+			switch pdata.subNode.(type) {
+			case *[]interface{}, validator.Map:
+				return true
+			}
+		}
+		return false
+	}
+	// 续行中沿用上一条记录中已有的数组/子消息/字典
+	existing := func(name string) interface{} {
+		if m, ok := curObj.(map[string]interface{}); ok && continuation {
+			return m[name]
+		}
+		return nil
+	}
+	curPath := ""
+	// 子节点在行中的路径, 数组元素为下标
+	childPath := func(name string) string {
+		if arr, ok := curObj.(*[]interface{}); ok {
+			return validator.IndexPath(curPath, len(*arr))
+		}
+		return validator.FieldPath(curPath, name)
+	}
 
-	t.curRow = rowi + 1 + len(t.header)
+	t.curRow = row.row
 	curObj = parsed
 	for k1, v1 := range row.Fields {
 		// 忽略第一列 ##name
@@ -247,12 +808,34 @@ func (t *TableData) parseRowData(rowi int) (map[string]interface{}, error) {
 		}
 		t.curColumn = k1
 		desc := t.rowDesc[k1]
+		exported := t.isColumnExported(k1, target)
 
 		for _, v2 := range desc.NestedField {
+			// 字典中的列不论是否导出都需要读取key
+			if dict, ok := curObj.(validator.Map); ok && v2.state == State_Set {
+				if err := t.setMapEntry(dict, objStack, v1, desc.ValueType, exported, curPath, cells); err != nil {
+					return nil, nil, err
+				}
+				continue
+			}
+			if v2.state == State_Set || v2.state == State_SetArr || v2.state == State_SetInline {
+				if !exported {
+					continue
+				}
+				for _, pdata := range objStack {
+					pdata.used = true
+				}
+			}
 			switch v2.state {
 			case State_Set:
+				if continuation && v1 != "" && !inArray() {
+					return nil, nil, t.Error("value %v should be in an array on continuation row", v1)
+				}
 				// 支持空值,
 				if v1 == "" {
+					if continuation {
+						continue
+					}
 					// 限制只能是array中消息为空, 或者array字段为空; 比如奖励多个物品, 有的奖励5个, 有个4个, 这个时候就有一个为空
 					if len(objStack) > 0 {
 						pdata := objStack[len(objStack)-1]
@@ -263,18 +846,22 @@ func (t *TableData) parseRowData(rowi int) (map[string]interface{}, error) {
 							continue
 						}
 					}
-					// 字符串支持空值
-					if desc.ValueType == "string" {
+					// 字符串和字典支持空值
+					if desc.ValueType == "string" || isMapType(desc.ValueType) {
 						continue
 					}
-					return nil, t.Error("value not set. column " + desc.FieldName + " row " + strconv.Itoa(rowi+1+len(t.header)))
+					// flags 空值为0
+					if !isFlagsType(desc.ValueType) {
+						return nil, nil, t.Error("value not set")
+					}
 				}
 				pv, err := parseFieldValue(v1, desc.ValueType)
 				if err != nil {
-					return nil, err
+					return nil, nil, t.Error("invalid value %v. %v", v1, err)
 				}
+				cells[childPath(v2.name)] = t.cellPos(t.curRow, k1)
 				if err := setCurValue(curObj, v2.name, pv); err != nil {
-					return nil, err
+					return nil, nil, t.Error(err.Error())
 				}
 			case State_SetArr:
 				// 支持空arr
@@ -282,59 +869,159 @@ func (t *TableData) parseRowData(rowi int) (map[string]interface{}, error) {
 					continue
 				}
 				if !strings.HasPrefix(v1, "[") || !strings.HasSuffix(v1, "]") {
-					return nil, t.Error("arrValue invalid. column " + desc.FieldName + " row " + strconv.Itoa(rowi+1+len(t.header)))
+					return nil, nil, t.Error("arrValue invalid")
 				}
-				strArr := strings.Split(v1[1:len(v1)-1], ",")
-				for _, sv := range strArr {
-					sv = strings.TrimSpace(sv)
-					if sv == "" {
-						continue
-					}
-					pv, err := parseFieldValue(sv, desc.ValueType)
-					if err != nil {
-						return nil, err
-					}
-					if err := setCurValue(curObj, v2.name, pv); err != nil {
-						return nil, err
+				// 和单元格中的数组使用同样的分词, 支持双引号中的逗号和括号
+				arrDesc := &InlineDesc{kind: Inline_Arr, elem: &InlineDesc{kind: Inline_Value, valueType: desc.ValueType}}
+				pv, err := parseInlineCell(v1, arrDesc, "", func(string) {})
+				if err != nil {
+					return nil, nil, t.Error("arrValue invalid. %v", err)
+				}
+				for _, sv := range *pv.(*[]interface{}) {
+					cells[childPath(v2.name)] = t.cellPos(t.curRow, k1)
+					if err := setCurValue(curObj, v2.name, sv); err != nil {
+						return nil, nil, t.Error(err.Error())
 					}
 				}
 
+			case State_SetInline:
+				// 支持空值
+				if v1 == "" {
+					continue
+				}
+				// 续行中单元格中的数组追加到上一条记录的数组中
+				appendTo, _ := existing(v2.name).(*[]interface{})
+				if continuation && !inArray() && v2.inline.kind != Inline_Arr {
+					return nil, nil, t.Error("value %v should be in an array on continuation row", v1)
+				}
+				path := childPath(v2.name)
+				pv, err := parseInlineCell(v1, v2.inline, path, func(p string) {
+					if appendTo != nil {
+						p = shiftIndexPath(p, path, len(*appendTo))
+					}
+					cells[p] = t.cellPos(t.curRow, k1)
+				})
+				if err != nil {
+					return nil, nil, t.Error("invalid value %v. %v", v1, err)
+				}
+				if pv == nil {
+					continue
+				}
+				if appendTo != nil {
+					*appendTo = append(*appendTo, *pv.(*[]interface{})...)
+					continue
+				}
+				if err := setCurValue(curObj, v2.name, pv); err != nil {
+					return nil, nil, t.Error(err.Error())
+				}
 			case State_ArrBegin:
 				arr := []interface{}{}
 				subObj := &arr
-				objStack = append(objStack, &PostSetData{node: curObj, key: v2.name, subNode: subObj})
+				if v, ok := existing(v2.name).(*[]interface{}); ok {
+					subObj = v
+				}
+				objStack = append(objStack, &PostSetData{node: curObj, key: v2.name, subNode: subObj, path: curPath})
+				curPath = childPath(v2.name)
 				curObj = subObj
 			case State_MsgBegin:
 				subObj := map[string]interface{}{}
-				objStack = append(objStack, &PostSetData{node: curObj, key: v2.name, subNode: subObj})
+				if v, ok := existing(v2.name).(map[string]interface{}); ok {
+					subObj = v
+				}
+				objStack = append(objStack, &PostSetData{node: curObj, key: v2.name, subNode: subObj, path: curPath})
+				curPath = childPath(v2.name)
+				curObj = subObj
+			case State_MapBegin:
+				subObj := validator.Map{}
+				if v, ok := existing(v2.name).(validator.Map); ok {
+					subObj = v
+				}
+				objStack = append(objStack, &PostSetData{node: curObj, key: v2.name, subNode: subObj, path: curPath})
+				curPath = childPath(v2.name)
 				curObj = subObj
 			case State_ArrEnd:
 				fallthrough
 			case State_MsgEnd:
+				fallthrough
+			case State_MapEnd:
 				pdata := objStack[len(objStack)-1]
-				setCurValue(pdata.node, pdata.key, curObj)
+				if pdata.used {
+					setCurValue(pdata.node, pdata.key, curObj)
+				}
 				objStack = objStack[:len(objStack)-1]
 				curObj = pdata.node
+				curPath = pdata.path
 
 			}
 		}
 	}
-	// need Id column
-	if _, ok := parsed["Id"]; !ok {
-		return nil, t.Error("missed Id column")
+	if continuation {
+		return parsed, cells, nil
+	}
+	// need key column
+	for _, k := range t.keyFields {
+		if _, ok := parsed[k]; !ok {
+			return nil, nil, t.Error("missed key column " + k)
+		}
 	}
 
-	return parsed, nil
+	return parsed, cells, nil
 }
 
-func (t *TableData) Error(format string, a ...interface{}) error {
-	fieldName := ""
-	if t.curColumn < len(t.rowDesc) {
-		fieldName = t.rowDesc[t.curColumn].FieldName
+// 字典中的列, 依次为 key 和 value. key 和 value 都为空时忽略
+func (t *TableData) setMapEntry(dict validator.Map, objStack []*PostSetData, value, valueType string, exported bool, path string, cells map[string]validator.CellPos) error {
+	pdata := objStack[len(objStack)-1]
+	pdata.mapIndex++
+	if pdata.mapIndex%2 == 1 {
+		pdata.mapKey, pdata.mapKeyType, pdata.mapKeyCol = value, valueType, t.curColumn
+		return nil
+	}
+	// value 的错误报在当前单元格, key 的错误报在 key 的单元格
+	valueCol := t.curColumn
+	if pdata.mapKey == "" {
+		if value == "" {
+			return nil
+		}
+		t.curColumn = pdata.mapKeyCol
+		return t.Error("map key not set")
+	}
+	if !exported {
+		return nil
+	}
+	if value == "" && valueType != "string" {
+		return t.Error("map value not set")
+	}
+	t.curColumn = pdata.mapKeyCol
+	key, err := parseMapKey(pdata.mapKey, pdata.mapKeyType)
+	if err != nil {
+		return t.Error("invalid map key %v. %v", pdata.mapKey, err)
+	}
+	if _, ok := dict[key]; ok {
+		return t.Error("duplicate map key %v", key)
+	}
+	t.curColumn = valueCol
+	pv, err := parseFieldValue(value, valueType)
+	if err != nil {
+		return t.Error("invalid value %v. %v", value, err)
 	}
-	prefix := fmt.Sprintf("data row %v column %v %v:", t.curRow, t.curColumn, fieldName)
+	for _, p := range objStack {
+		p.used = true
+	}
+	cells[validator.FieldPath(path, key)] = t.cellPos(t.curRow, t.curColumn)
+	dict[key] = pv
+	return nil
+}
+
+// 当前单元格的错误, 比如 excel/monster.xlsx!MonsterConfig!F12 Name: value not set
+func (t *TableData) Error(format string, a ...interface{}) error {
 	errstr := fmt.Sprintf(format, a...)
-	return errors.New(prefix + errstr)
+	if t.curColumn > 0 && t.curColumn < len(t.rowDesc) {
+		errstr = t.rowDesc[t.curColumn].FieldName + ": " + errstr
+	}
+	return &validator.CellError{
+		Pos: t.cellPos(t.curRow, t.curColumn),
+		Err: errors.New(errstr),
+	}
 }
 
 func setCurValue(node interface{}, k string, v interface{}) error {
@@ -347,6 +1034,11 @@ func setCurValue(node interface{}, k string, v interface{}) error {
 			return nil
 		}
 	}
+	if mv, ok := v.(validator.Map); ok {
+		if len(mv) == 0 {
+			return nil
+		}
+	}
 
 	if m, ok := node.(map[string]interface{}); ok {
 		if k == "" {
@@ -362,7 +1054,7 @@ func setCurValue(node interface{}, k string, v interface{}) error {
 
 }
 
-func (t *TableData) ExportJson(w io.Writer) error {
+func (t *TableData) ExportJson(w io.Writer, parsedData map[interface{}]map[string]interface{}) error {
 	c := json.Config{
 		SortMapKeys: true,
 		//EscapeHTML:  true,
@@ -371,30 +1063,91 @@ func (t *TableData) ExportJson(w io.Writer) error {
 	}
 
 	e := c.Froze().NewEncoder(w)
+	if int64AsString {
+		data := make(map[interface{}]map[string]interface{}, len(parsedData))
+		for k, r := range parsedData {
+			data[k] = stringifyInt64(r).(map[string]interface{})
+		}
+		parsedData = data
+	}
 	// 单行配置
-	if len(t.parsedData) == 1 {
-		for k, v := range t.parsedData {
-			if k.(int32) == 0 {
-				if err := e.Encode(v); err != nil {
-					return err
-				}
-				return nil
-			}
-			break
+	if t.isSingleObject() {
+		for _, v := range parsedData {
+			return e.Encode(v)
 		}
 	}
 
-	if err := e.Encode(t.parsedData); err != nil {
+	if err := e.Encode(parsedData); err != nil {
 		return err
 	}
 	return nil
 
 }
 
+// int64 转为字符串, 返回转换后的副本, 解析的数据不变
+func stringifyInt64(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for k, sv := range v {
+			res[k] = stringifyInt64(sv)
+		}
+		return res
+	case validator.Map:
+		res := make(validator.Map, len(v))
+		for k, sv := range v {
+			res[k] = stringifyInt64(sv)
+		}
+		return res
+	case *[]interface{}:
+		res := make([]interface{}, 0, len(*v))
+		for _, sv := range *v {
+			res = append(res, stringifyInt64(sv))
+		}
+		return &res
+	default:
+		return v
+	}
+}
+
+// 单行配置导出为一个对象: 竖排的全局配置, 或者只有一行并且主键为0的普通表.
+// 按完整数据判断, 各目标的json和生成的代码都一致
+func (t *TableData) isSingleObject() bool {
+	if t.layout != nil {
+		return true
+	}
+	if len(t.parsedData) != 1 {
+		return false
+	}
+	for k := range t.parsedData {
+		return k == int32(0) || k == int64(0)
+	}
+	return false
+}
+
 func parseNestedFieldDesc(desc *FieldDesc) error {
 	start := 0
 	for cur := 0; start < len(desc.FieldName); cur++ {
 		if cur < len(desc.FieldName) {
+			// 单元格中的结构
+			if c := desc.FieldName[cur]; c == '[' || c == '{' {
+				if end, ok := inlineFieldEnd(desc.FieldName, cur); ok {
+					if desc.inline() != nil {
+						return errors.New("only one inline field in a column")
+					}
+					inline, err := parseInlineDesc(desc.FieldName[cur:end+1], splitInlineTypes(desc.ValueType))
+					if err != nil {
+						return err
+					}
+					name := strings.TrimSpace(desc.FieldName[start:cur])
+					desc.NestedField = append(desc.NestedField, NestedFieldDesc{name: name, state: State_SetInline, inline: inline})
+					start = end + 1
+					cur = end
+					continue
+				}
+			}
 			switch desc.FieldName[cur] {
 			case '[':
 				name := strings.TrimSpace(desc.FieldName[start:cur])
@@ -412,6 +1165,10 @@ func parseNestedFieldDesc(desc *FieldDesc) error {
 				name := strings.TrimSpace(desc.FieldName[start:cur])
 				start = cur + 1
 				desc.NestedField = append(desc.NestedField, NestedFieldDesc{name: name, state: State_MsgBegin})
+			case '<':
+				name := strings.TrimSpace(desc.FieldName[start:cur])
+				start = cur + 1
+				desc.NestedField = append(desc.NestedField, NestedFieldDesc{name: name, state: State_MapBegin})
 			case ']':
 				name := strings.TrimSpace(desc.FieldName[start:cur])
 				start = cur + 1
@@ -427,6 +1184,13 @@ func parseNestedFieldDesc(desc *FieldDesc) error {
 					desc.NestedField = append(desc.NestedField, NestedFieldDesc{name: name, state: State_Set})
 				}
 				desc.NestedField = append(desc.NestedField, NestedFieldDesc{name: "", state: State_MsgEnd})
+			case '>':
+				name := strings.TrimSpace(desc.FieldName[start:cur])
+				start = cur + 1
+				if len(name) > 0 {
+					desc.NestedField = append(desc.NestedField, NestedFieldDesc{name: name, state: State_Set})
+				}
+				desc.NestedField = append(desc.NestedField, NestedFieldDesc{name: "", state: State_MapEnd})
 			default:
 
 			}
@@ -453,6 +1217,30 @@ func getCelValue(sheet *xlsx.Sheet, row int, col int) string {
 	}
 }
 
+// 浮点数导出保留的小数位数, 小于0时不做处理
+var floatDecimals = -1
+
+func roundFloat(v float64) float64 {
+	if floatDecimals < 0 {
+		return v
+	}
+	p := math.Pow10(floatDecimals)
+	// 很大的值乘以 p 会溢出为 Inf, 保持原值
+	if r := math.Round(v*p) / p; !math.IsInf(r, 0) {
+		return r
+	}
+	return v
+}
+
+// json 中不能表示 NaN 和 Inf, 解析时报错
+func parseFloat(value string, bitSize int) (float64, error) {
+	v, err := strconv.ParseFloat(value, bitSize)
+	if err == nil && (math.IsNaN(v) || math.IsInf(v, 0)) {
+		return 0, errors.New("NaN and Inf are not supported")
+	}
+	return v, err
+}
+
 func isValueTypeValid(valueType string) bool {
 	switch valueType {
 	case "string":
@@ -463,16 +1251,99 @@ func isValueTypeValid(valueType string) bool {
 		fallthrough
 	case "int64":
 		fallthrough
-		//case "float":
-		//fallthrough
-		//case "double":
-		//fallthrough
+	case "float": // 32位浮点数
+		fallthrough
+	case "double":
+		fallthrough
 	case "bool":
 		return true
+	default:
+		if kind, name, ok := parseGenericType(valueType); ok {
+			switch kind {
+			case "enum":
+				return enums[name] != nil
+			case "flags":
+				return enums[name] != nil && enums[name].validFlags()
+			case "map":
+				keyType, valueType, ok := splitMapType(name)
+				keyKind, _, _ := parseGenericType(keyType)
+				return ok && (isKeyTypeValid(keyType) || keyKind == "enum") && isValueTypeValid(keyType) &&
+					!isMapType(valueType) && isValueTypeValid(valueType)
+			}
+		}
+		return false
+	}
+}
+
+// 类型是否 map<string,int>
+func isMapType(valueType string) bool {
+	kind, _, ok := parseGenericType(valueType)
+	return ok && kind == "map"
+}
+
+// map<string,int> 的类型参数 string,int
+func splitMapType(arg string) (string, string, bool) {
+	pos := strings.Index(arg, ",")
+	if pos < 0 {
+		return "", "", false
+	}
+	return strings.TrimSpace(arg[:pos]), strings.TrimSpace(arg[pos+1:]), true
+}
+
+// 字典的key按类型检查后转为字符串
+func parseMapKey(value, keyType string) (string, error) {
+	pv, err := parseFieldValue(value, keyType)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprint(pv), nil
+}
+
+// 单元格中的字典, 格式 Atk:10;Def:5
+func parseInlineMap(value, arg string) (interface{}, error) {
+	keyType, valueType, _ := splitMapType(arg)
+	dict := validator.Map{}
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		kv := strings.SplitN(entry, ":", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("map entry %v should be key:value", entry)
+		}
+		key, err := parseMapKey(strings.TrimSpace(kv[0]), keyType)
+		if err != nil {
+			return nil, fmt.Errorf("invalid map key %v. %v", kv[0], err)
+		}
+		if _, ok := dict[key]; ok {
+			return nil, fmt.Errorf("duplicate map key %v", key)
+		}
+		pv, err := parseFieldValue(strings.TrimSpace(kv[1]), valueType)
+		if err != nil {
+			return nil, err
+		}
+		dict[key] = pv
+	}
+	return dict, nil
+}
+
+// 主键只支持整数和字符串
+func isKeyTypeValid(valueType string) bool {
+	switch valueType {
+	case "string":
+		fallthrough
+	case "int":
+		fallthrough
+	case "int32":
+		fallthrough
+	case "int64":
+		return true
 	default:
 		return false
 	}
 }
+
 func parseFieldValue(value string, valueType string) (interface{}, error) {
 	switch valueType {
 	case "string":
@@ -485,79 +1356,305 @@ func parseFieldValue(value string, valueType string) (interface{}, error) {
 	case "int64":
 		return strconv.ParseInt(value, 10, 64)
 	case "float":
-		fallthrough
+		v, err := parseFloat(value, 32)
+		return float32(roundFloat(v)), err
 	case "double":
-		return strconv.ParseFloat(value, 64)
+		v, err := parseFloat(value, 64)
+		return roundFloat(v), err
 	case "bool":
 		return strconv.ParseBool(value)
 	default:
+		if kind, name, ok := parseGenericType(valueType); ok {
+			switch kind {
+			case "enum":
+				if enums[name] != nil {
+					return enums[name].parse(value)
+				}
+			case "flags":
+				if enums[name] != nil {
+					return enums[name].parseFlags(value)
+				}
+			case "map":
+				return parseInlineMap(value, name)
+			}
+		}
 		return nil, errors.New("unsupport type " + valueType)
 	}
 }
 
-func ConvertDir(inputDir string, output string) error {
-
+func ConvertDir(inputDir string) ([]*TableData, error) {
+	files := []string{}
 	err := filepath.WalkDir(inputDir, func(path string, f fs.DirEntry, err error) error {
 		file := filepath.Base(path)
 		if strings.HasSuffix(strings.ToLower(file), ".xlsx") && !strings.HasPrefix(file, "~") {
-			return ConvertFile(path, output)
+			files = append(files, path)
 		}
 		return nil
 	})
-	return err
+	if err != nil {
+		return nil, err
+	}
+	return ConvertFiles(files)
 }
 
-func ConvertFile(filename string, output string) error {
-	wb, err := xlsx.OpenFile(filename)
-	if err != nil {
-		panic(err)
+// 先读取所有excel中的枚举定义, 再转换配置sheet.
+// 错误记录到errReport中, 返回非空时需要停止转换
+func ConvertFiles(files []string) ([]*TableData, error) {
+	workbooks := make([]*xlsx.File, len(files))
+	for i, filename := range files {
+		wb, err := xlsx.OpenFile(filename)
+		if err != nil {
+			if err := errReport.Add(filename, "", "", err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		workbooks[i] = wb
+		for _, sheet := range wb.Sheets {
+			if !isEnumSheet(sheet) {
+				continue
+			}
+			if err := readEnumSheet(filename, sheet); err != nil {
+				return nil, err
+			}
+		}
 	}
+	tables := []*TableData{}
+	for i, wb := range workbooks {
+		if wb == nil {
+			continue
+		}
+		fileTables, err := ConvertFile(files[i], wb)
+		tables = append(tables, fileTables...)
+		if err != nil {
+			return tables, err
+		}
+	}
+	return tables, nil
+}
 
+// 读取excel中所有配置sheet, 不写出文件.
+// 错误记录到errReport中, 返回非空时需要停止转换
+func ConvertFile(filename string, wb *xlsx.File) ([]*TableData, error) {
+	tables := []*TableData{}
 	for _, sheet := range wb.Sheets {
-		if !strings.HasSuffix(sheet.Name, "Config") && !strings.HasSuffix(sheet.Name, "Cfg") {
+		if !strings.HasSuffix(sheet.Name, "Config") && !strings.HasSuffix(sheet.Name, "Cfg") && !isKVSheet(sheet) {
+			continue
+		}
+		if isEnumSheet(sheet) {
 			continue
 		}
 		fmt.Printf("convert file %v sheet %v\n", filename, sheet.Name)
+		errReport.AddSheet(filename, sheet.Name)
 		tableData := &TableData{
-			sheet:  sheet,
-			header: map[string]*RowData{},
+			filename: filename,
+			sheet:    sheet,
+			header:   map[string]*RowData{},
 		}
 		if err := tableData.ReadXlsxSheet(); err != nil {
-			panic("error:" + filename + ":" + err.Error())
+			return tables, err
 		}
-		// set output
-		outputfile := filepath.Join(output, sheet.Name+".json")
-		f, err := os.OpenFile(outputfile, os.O_CREATE|os.O_TRUNC|os.O_RDWR, fs.ModePerm)
-		if err != nil {
-			panic(err)
+		if tableData.parsedData == nil {
+			continue
+		}
+		tables = append(tables, tableData)
+	}
+	return tables, nil
+}
+
+// 合并不同excel中的同名sheet, 并加入规则检查. 出错的行已跳过, 剩余的数据继续做规则检查.
+// 错误记录到errReport中, 返回非空时需要停止转换
+func MergeTables(tables []*TableData) ([]*TableData, error) {
+	merged := []*TableData{}
+	byName := map[string]*TableData{}
+	for _, tableData := range tables {
+		if first, ok := byName[tableData.sheet.Name]; ok {
+			if err := first.merge(tableData); err != nil {
+				return merged, err
+			}
+			continue
+		}
+		byName[tableData.sheet.Name] = tableData
+		merged = append(merged, tableData)
+	}
+	for _, tableData := range merged {
+		validator.Instance().AddTableData(tableData.sheet.Name, &validator.Table{
+			Rows:      tableData.parsedData,
+			Sources:   tableData.sources,
+			KeyFields: tableData.keyFields,
+			Columns:   tableData.columnPaths(),
+		})
+	}
+	return merged, nil
+}
+
+// targets为空时导出所有列到output, 否则每个目标导出到output下同名目录
+func ExportTables(tables []*TableData, output string, targets []string) error {
+	for _, target := range targets {
+		if err := os.MkdirAll(filepath.Join(output, target), os.ModePerm); err != nil {
+			return err
+		}
+	}
+	for _, tableData := range tables {
+		name := tableData.sheet.Name + ".json"
+		if len(targets) == 0 {
+			if err := tableData.exportFile(filepath.Join(output, name), tableData.parsedData); err != nil {
+				return err
+			}
+			if err := tableData.exportJsonSchema(output, ""); err != nil {
+				return err
+			}
+			if err := tableData.exportProtoBytes(output, "", tableData.parsedData); err != nil {
+				return err
+			}
+		}
+		for _, target := range targets {
+			parsedData, err := tableData.exportData(target)
+			if err != nil {
+				return err
+			}
+			if err := tableData.exportFile(filepath.Join(output, target, name), parsedData); err != nil {
+				return err
+			}
+			if err := tableData.exportJsonSchema(filepath.Join(output, target), target); err != nil {
+				return err
+			}
+			if err := tableData.exportProtoBytes(filepath.Join(output, target), target, parsedData); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// 在json同一目录写出 MonsterConfig.schema.json
+func (t *TableData) exportJsonSchema(dir, target string) error {
+	if !exportJsonSchema {
+		return nil
+	}
+	data, err := t.schema(target).jsonSchema()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, t.sheet.Name+".schema.json"), data, 0644)
+}
+
+func (t *TableData) exportFile(outputfile string, parsedData map[interface{}]map[string]interface{}) error {
+	f, err := os.OpenFile(outputfile, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return t.ExportJson(f, parsedData)
+}
+
+// 先写到output同级的临时目录, 全部成功后再替换output, 保证output不会是写了一半的状态
+func writeOutput(output string, write func(dir string) error) error {
+	parent, base := filepath.Split(filepath.Clean(output))
+	if parent == "" {
+		parent = "."
+	}
+	if err := os.MkdirAll(parent, os.ModePerm); err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(parent, base+".tmp")
+	if err != nil {
+		return err
+	}
+	if err := write(tmp); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	if err := os.Chmod(tmp, 0755); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+
+	old := ""
+	if _, err := os.Stat(output); err == nil {
+		old = tmp + ".old"
+		if err := os.Rename(output, old); err != nil {
+			os.RemoveAll(tmp)
+			return err
 		}
-		if err := tableData.ExportJson(f); err != nil {
-			panic("error:" + filename + ":" + err.Error())
+	}
+	if err := os.Rename(tmp, output); err != nil {
+		// 还原旧的输出
+		if old != "" {
+			os.Rename(old, output)
 		}
-		validator.Instance().AddTableData(sheet.Name, tableData.parsedData)
+		os.RemoveAll(tmp)
+		return err
+	}
+	if old != "" {
+		return os.RemoveAll(old)
 	}
 	return nil
 }
 
+// path 是否为 dir 或者 dir 下的路径
+func isSubPath(dir, path string) bool {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(absDir, absPath)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
 func main() {
 	flagInput := flag.String("i", "./excel", "input excel folder")
 	flagOutput := flag.String("o", "./outjson", "output json folder")
+	flagTarget := flag.String("target", "", "export targets separated by comma, e.g. c,s. each target is written to its own folder")
+	flagAssets := flag.String("assets", ".", "asset root folder for path validator")
+	flag.IntVar(&floatDecimals, "decimals", -1, "decimal places of float/double values, negative keeps full precision")
+	flag.BoolVar(&errReport.keepGoing, "keepgoing", false, "continue after errors and report all of them at the end")
+	flag.IntVar(&errReport.maxErrors, "maxerrors", 0, "stop after this many errors when -keepgoing, 0 means no limit")
+	flagReport := flag.String("report", "", "write diagnostics to <report>.json and JUnit XML <report>.xml")
+	flag.BoolVar(&flagsAsNames, "flagnames", false, "export flags<> columns as arrays of names instead of combined integers")
+	flag.BoolVar(&overrideDuplicate, "override", false, "rows with duplicate keys override earlier rows with a warning instead of failing")
+	flagGo := flag.String("go", "", "generate go structs and loader to this folder")
+	flagGoPackage := flag.String("gopackage", "", "package name of generated go code, default is the folder name")
+	flagCSharp := flag.String("cs", "", "generate c# classes to this folder")
+	flagCSharpNamespace := flag.String("csnamespace", "Config", "namespace of generated c# classes, empty for global namespace")
+	flag.BoolVar(&exportJsonSchema, "jsonschema", false, "write JSON Schema <sheet>.schema.json beside each json")
+	flagTypeScript := flag.String("ts", "", "generate typescript definitions (.d.ts) to this folder")
+	flagProto := flag.String("proto", "", "generate .proto files to this folder and write binary <sheet>.bytes beside each json. field numbers are kept in fieldnumbers.json of this folder")
+	flagProtoPackage := flag.String("protopackage", "config", "package of generated .proto files")
+	flagTSInt64 := flag.String("tsint64", "string", "typescript type of int64 fields, string or bigint. with -ts, string also writes int64 values as json strings")
 	flag.Parse()
 
+	validator.Instance().SetAssetRoot(*flagAssets)
+	validator.Instance().SetErrorHandler(func(e *validator.RuleError) error {
+		return errReport.Add(e.Pos.File, e.Pos.Sheet, e.Rule.String(), e)
+	})
+
+	var targets []string
+	for _, target := range strings.Split(*flagTarget, ",") {
+		if target = strings.TrimSpace(target); target != "" {
+			targets = append(targets, target)
+		}
+	}
+
+	if *flagTSInt64 != "string" && *flagTSInt64 != "bigint" {
+		fmt.Printf("tsint64 should be string or bigint, got %v", *flagTSInt64)
+		os.Exit(-1)
+	}
+	int64AsString = *flagTypeScript != "" && *flagTSInt64 == "string"
+
 	ifs, err := os.Stat(*flagInput)
 	if err != nil {
 		fmt.Printf("read %v error. %v", *flagInput, err)
 		os.Exit(-1)
 	}
 
-	fullOutput := filepath.Join(".", *flagOutput)
-	if ofs, err := os.Stat(*flagOutput); err != nil {
-		if os.IsNotExist(err) {
-			if err := os.MkdirAll(fullOutput, os.ModePerm); err != nil {
-				fmt.Printf("open %v error. %v", *flagOutput, err)
-				os.Exit(-1)
-			}
-		} else {
+	fullOutput := filepath.Clean(*flagOutput)
+	if ofs, err := os.Stat(fullOutput); err != nil {
+		if !os.IsNotExist(err) {
 			fmt.Printf("open %v error. %v", *flagOutput, err)
 			os.Exit(-1)
 		}
@@ -567,22 +1664,127 @@ func main() {
 			os.Exit(-1)
 		}
 	}
+	// 输出目录整体替换, 其中的其他文件会被删除, 代码和报告不能写到输出目录中
+	for _, v := range []struct{ name, path string }{
+		{"go", *flagGo}, {"cs", *flagCSharp}, {"ts", *flagTypeScript}, {"proto", *flagProto}, {"report", *flagReport},
+	} {
+		if v.path != "" && isSubPath(fullOutput, v.path) {
+			fmt.Printf("-%v %v should not be inside output %v", v.name, v.path, *flagOutput)
+			os.Exit(-1)
+		}
+	}
 
-	if ifs.IsDir() {
-		if err := ConvertDir(*flagInput, fullOutput); err != nil {
-			panic(err)
+	if *flagProto != "" {
+		if protoFields, err = loadProtoFieldNumbers(*flagProto); err != nil {
+			fmt.Printf("%v\n", err)
+			os.Exit(-1)
 		}
+	}
+
+	// 先读取所有表并做规则检查, 全部通过后才写出
+	var tables []*TableData
+	if ifs.IsDir() {
+		tables, err = ConvertDir(*flagInput)
 	} else {
-		if err := ConvertFile(*flagInput, fullOutput); err != nil {
-			panic(err)
+		tables, err = ConvertFiles([]string{*flagInput})
+	}
+	if err == nil {
+		tables, err = MergeTables(tables)
+	}
+
+	if err == nil {
+		errCount := errReport.Count()
+		if err := validator.Instance().Validate(); err == nil && errReport.Count() == errCount {
+			fmt.Println("validator verify succ.")
 		}
 	}
 
-	if err := validator.Instance().Validate(); err != nil {
-		panic(err)
-	} else {
-		fmt.Println("validator verify succ.")
+	// 生成代码, 每个目标生成到目录下的同名目录
+	codeOutputs := []codeOutput{}
+	if *flagGo != "" {
+		pkg := *flagGoPackage
+		if pkg == "" {
+			pkg = filepath.Base(filepath.Clean(*flagGo))
+		}
+		codeOutputs = append(codeOutputs, codeOutput{dir: *flagGo, gen: &goGenerator{pkg: pkg}})
+	}
+	if *flagCSharp != "" {
+		codeOutputs = append(codeOutputs, codeOutput{dir: *flagCSharp, gen: &csharpGenerator{namespace: *flagCSharpNamespace}})
+	}
+	if *flagTypeScript != "" {
+		codeOutputs = append(codeOutputs, codeOutput{dir: *flagTypeScript, gen: &typescriptGenerator{int64Type: *flagTSInt64}})
+	}
+	if protoFields != nil {
+		codeOutputs = append(codeOutputs, codeOutput{dir: *flagProto, gen: &protoGenerator{pkg: *flagProtoPackage, numbers: protoFields}})
+	}
+	// 代码和字段编号先全部生成并写到临时文件, json 写出成功后再替换, 失败时输出都保持不变
+	staged := []*stagedFiles{}
+	if errReport.Count() == 0 {
+		if protoFields != nil {
+			protoFields.assign(tables)
+		}
+		for _, output := range codeOutputs {
+			files, err := GenerateCode(tables, output.dir, targets, output.gen)
+			if err != nil {
+				errReport.Add(output.dir, "", "", err)
+				break
+			}
+			staged = append(staged, &stagedFiles{dir: output.dir, files: files})
+		}
+		if protoFields != nil && errReport.Count() == 0 {
+			if f, err := protoFields.file(); err != nil {
+				errReport.Add(protoFields.filename, "", "", err)
+			} else {
+				staged = append(staged, &stagedFiles{dir: *flagProto, files: []codeFile{f}})
+			}
+		}
+		for _, s := range staged {
+			if errReport.Count() > 0 {
+				break
+			}
+			if err := s.stage(); err != nil {
+				errReport.Add(s.dir, "", "", err)
+			}
+		}
+	}
+
+	written := false
+	if errReport.Count() == 0 {
+		err := writeOutput(fullOutput, func(dir string) error {
+			return ExportTables(tables, dir, targets)
+		})
+		if err != nil {
+			errReport.Add(fullOutput, "", "", err)
+		} else {
+			written = true
+		}
+	}
+	for _, s := range staged {
+		if written && errReport.Count() == 0 {
+			if err := s.commit(); err != nil {
+				errReport.Add(s.dir, "", "", err)
+			}
+		} else {
+			s.discard()
+		}
 	}
 
+	if *flagReport != "" {
+		if err := errReport.Write(*flagReport); err != nil {
+			fmt.Printf("write report %v error. %v\n", *flagReport, err)
+			os.Exit(-1)
+		}
+	}
+	if !errReport.Empty() {
+		errReport.Print()
+	}
+	if errReport.Count() > 0 {
+		if written {
+			fmt.Printf("output %v written, generated code not fully updated.\n", fullOutput)
+		} else {
+			fmt.Printf("output %v not changed.\n", fullOutput)
+		}
+		os.Exit(-1)
+	}
 	fmt.Println("convert finish.")
 }
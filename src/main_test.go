@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func TestExportSingleRowTable(t *testing.T) {
+	tests := []struct {
+		name    string
+		rows    [][]string
+		want    string
+		keyType string
+	}{
+		{name: "single row id 0", rows: [][]string{{"", "0", "100"}}, want: `{"Id":0,"MaxLv":100}`, keyType: ""},
+		{name: "single row id 1", rows: [][]string{{"", "1", "100"}}, want: `{"1":{"Id":1,"MaxLv":100}}`, keyType: "int32"},
+		{name: "two rows", rows: [][]string{{"", "0", "100"}, {"", "1", "200"}}, want: `{"0":{"Id":0,"MaxLv":100},"1":{"Id":1,"MaxLv":200}}`, keyType: "int32"},
+	}
+	for _, tt := range tests {
+		filename := filepath.Join(t.TempDir(), "global.xlsx")
+		rows := append([][]string{{"##name", "Id", "MaxLv"}, {"##type", "int", "int"}}, tt.rows...)
+		writeTestWorkbook(t, filename, "GlobalConfig", rows)
+		tables, err := ConvertFiles([]string{filename})
+		if err != nil {
+			t.Fatalf("%v: %v", tt.name, err)
+		}
+		var buf bytes.Buffer
+		if err := tables[0].ExportJson(&buf, tables[0].parsedData); err != nil {
+			t.Fatalf("%v: %v", tt.name, err)
+		}
+		if got := string(bytes.Join(bytes.Fields(buf.Bytes()), nil)); got != tt.want {
+			t.Errorf("%v: got %v, want %v", tt.name, got, tt.want)
+		}
+		// 生成的代码和json的结构一致
+		if got := tables[0].schema("").keyType; got != tt.keyType {
+			t.Errorf("%v: key type got %q, want %q", tt.name, got, tt.keyType)
+		}
+	}
+}
+
+func TestExportInt64AsString(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "monster.xlsx")
+	writeTestWorkbook(t, filename, "MonsterConfig", [][]string{
+		{"##name", "Id", "Hp", "Tags[]", "Attr", "Drop{Num", "ItemId}"},
+		{"##type", "int", "int64", "int64", "map<int64,int64>", "int64", "int"},
+		{"", "1", "9007199254740993", "[1,-2]", "5:9007199254740993", "7", "1001"},
+	})
+	tables, err := ConvertFiles([]string{filename})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(v bool) { int64AsString = v }(int64AsString)
+	int64AsString = true
+	var buf bytes.Buffer
+	if err := tables[0].ExportJson(&buf, tables[0].parsedData); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"1":{"Attr":{"5":"9007199254740993"},"Drop":{"ItemId":1001,"Num":"7"},"Hp":"9007199254740993","Id":1,"Tags":["1","-2"]}}`
+	if got := string(bytes.Join(bytes.Fields(buf.Bytes()), nil)); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// 解析的数据不变, 规则检查和 .bytes 仍然使用 int64
+	if v, ok := tables[0].parsedData[int32(1)]["Hp"].(int64); !ok || v != 9007199254740993 {
+		t.Errorf("parsed Hp changed: %v", tables[0].parsedData[int32(1)]["Hp"])
+	}
+}
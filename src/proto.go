@@ -0,0 +1,484 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/laozhuzz/excel2json/validator"
+)
+
+//
+// protobuf. 每个表生成一个 MonsterConfig.proto (proto3), 子消息为单独命名的message, 数组为repeated,
+// 表为 MonsterConfigTable { map<int32, MonsterConfig> Rows = 1; }; 导出json时同时写出二进制的 MonsterConfig.bytes.
+// 字段编号记录在 proto 目录下的 fieldnumbers.json 中, 新加的列使用新的编号, 已有的字段编号不变, 删除的字段编号保留不再使用
+//
+
+const protoFieldNumbersFile = "fieldnumbers.json"
+
+// 字段编号, message名 -> 字段名 -> 编号
+type protoFieldNumbers struct {
+	filename string
+	messages map[string]map[string]int
+}
+
+// 导出json时同时写出 .bytes, 为空时不导出
+var protoFields *protoFieldNumbers
+
+func loadProtoFieldNumbers(dir string) (*protoFieldNumbers, error) {
+	p := &protoFieldNumbers{
+		filename: filepath.Join(dir, protoFieldNumbersFile),
+		messages: map[string]map[string]int{},
+	}
+	data, err := os.ReadFile(p.filename)
+	if os.IsNotExist(err) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &p.messages); err != nil {
+		return nil, fmt.Errorf("read %v. %v", p.filename, err)
+	}
+	return p, nil
+}
+
+// 记录字段编号的文件
+func (p *protoFieldNumbers) file() (codeFile, error) {
+	data, err := json.MarshalIndent(p.messages, "", "  ")
+	if err != nil {
+		return codeFile{}, err
+	}
+	return codeFile{name: p.filename, content: append(data, '\n')}, nil
+}
+
+// 先按所有列分配字段编号, 各目标中的编号一致
+func (p *protoFieldNumbers) assign(tables []*TableData) {
+	for _, tableData := range tables {
+		p.tableMessages(tableData.schema(""))
+	}
+}
+
+// 字段的编号, 没有时分配一个没有使用过的编号
+func (p *protoFieldNumbers) number(message, field string) int {
+	fields := p.messages[message]
+	if fields == nil {
+		fields = map[string]int{}
+		p.messages[message] = fields
+	}
+	if n, ok := fields[field]; ok {
+		return n
+	}
+	n := 1
+	for _, v := range fields {
+		if v >= n {
+			n = v + 1
+		}
+	}
+	// 19000-19999 为protobuf保留的编号
+	if n >= 19000 && n <= 19999 {
+		n = 20000
+	}
+	fields[field] = n
+	return n
+}
+
+// 不再使用的编号
+func (p *protoFieldNumbers) reserved(message string, used map[int]bool) []int {
+	numbers := []int{}
+	for _, n := range p.messages[message] {
+		if !used[n] {
+			numbers = append(numbers, n)
+		}
+	}
+	sort.Ints(numbers)
+	return numbers
+}
+
+type protoMessage struct {
+	name   string
+	desc   string
+	fields []*protoField
+	// 不再使用的编号
+	reserved []int
+}
+
+type protoField struct {
+	name   string
+	number int
+	node   *SchemaNode
+	// 字段的类型, 比如 repeated int32, map<string, int32>
+	typeName string
+	// 数组的数组, 数组的元素为只有一个字段的message
+	wrapper *protoMessage
+	// 子消息
+	message *protoMessage
+	// 数组中的普通值, 编码为packed
+	packed bool
+}
+
+// 按表结构构建message, 子消息和数组的数组依次加入messages中
+func (p *protoFieldNumbers) buildMessage(name string, node *SchemaNode, messages *[]*protoMessage) *protoMessage {
+	msg := &protoMessage{name: name, desc: node.desc}
+	*messages = append(*messages, msg)
+	used := map[int]bool{}
+	for _, f := range node.fields {
+		fieldName := protoName(f.name)
+		field := p.buildField(name+exportedName(fieldName), f, messages)
+		field.name = fieldName
+		field.number = p.number(name, f.name)
+		used[field.number] = true
+		msg.fields = append(msg.fields, field)
+	}
+	msg.reserved = p.reserved(name, used)
+	return msg
+}
+
+func (p *protoFieldNumbers) buildField(name string, node *SchemaNode, messages *[]*protoMessage) *protoField {
+	field := &protoField{node: node}
+	switch node.kind {
+	case Schema_Arr:
+		switch node.elem.kind {
+		case Schema_Msg:
+			field.message = p.buildMessage(name, node.elem, messages)
+			field.typeName = "repeated " + name
+		case Schema_Value:
+			field.typeName = "repeated " + scalarType(node.elem.valueType)
+			field.packed = field.typeName != "repeated string"
+		default:
+			// repeated 中不能是 repeated 或者 map, 使用只有一个字段 Items 的message
+			wrapper := &protoMessage{name: name + "Item", desc: node.desc}
+			*messages = append(*messages, wrapper)
+			item := p.buildField(name+"Item", node.elem, messages)
+			item.name, item.number = "Items", 1
+			wrapper.fields = []*protoField{item}
+			field.wrapper = wrapper
+			field.typeName = "repeated " + wrapper.name
+		}
+	case Schema_Msg:
+		field.message = p.buildMessage(name, node, messages)
+		field.typeName = name
+	case Schema_Map:
+		field.typeName = fmt.Sprintf("map<%v, %v>", scalarType(node.keyType), scalarType(node.valueType))
+	default:
+		field.typeName = scalarType(node.valueType)
+	}
+	return field
+}
+
+// 表的所有message, 第一个为行
+func (p *protoFieldNumbers) tableMessages(schema *TableSchema) []*protoMessage {
+	messages := []*protoMessage{}
+	p.buildMessage(exportedName(protoName(schema.Name)), schema.root, &messages)
+	return messages
+}
+
+// proto中的名字只能是字母数字和_
+func protoName(name string) string {
+	runes := []byte{}
+	for _, r := range name {
+		if r < 128 && (r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			runes = append(runes, byte(r))
+		} else {
+			runes = append(runes, '_')
+		}
+	}
+	if len(runes) == 0 || runes[0] >= '0' && runes[0] <= '9' || runes[0] == '_' {
+		return "F" + string(runes)
+	}
+	return string(runes)
+}
+
+type protoGenerator struct {
+	pkg     string
+	numbers *protoFieldNumbers
+}
+
+func (g *protoGenerator) generate(schemas []*TableSchema) ([]codeFile, error) {
+	files := []codeFile{}
+	for _, schema := range schemas {
+		var buf bytes.Buffer
+		buf.WriteString("// Generated by excel2json. DO NOT EDIT.\n")
+		buf.WriteString("syntax = \"proto3\";\n\n")
+		if g.pkg != "" {
+			fmt.Fprintf(&buf, "package %v;\n", g.pkg)
+		}
+		messages := g.numbers.tableMessages(schema)
+		row := messages[0]
+		if schema.keyType == "" {
+			row.desc = schema.Name + ".bytes 中的配置"
+		} else {
+			row.desc = schema.Name + ".bytes 中的一行"
+		}
+		for _, msg := range messages {
+			buf.WriteString("\n")
+			writeProtoComment(&buf, "", msg.desc)
+			fmt.Fprintf(&buf, "message %v {\n", msg.name)
+			if len(msg.reserved) > 0 {
+				reserved := make([]string, 0, len(msg.reserved))
+				for _, n := range msg.reserved {
+					reserved = append(reserved, strconv.Itoa(n))
+				}
+				fmt.Fprintf(&buf, "  reserved %v;\n", strings.Join(reserved, ", "))
+			}
+			for _, f := range msg.fields {
+				writeProtoComment(&buf, "  ", f.node.desc)
+				fmt.Fprintf(&buf, "  %v %v = %v;\n", f.typeName, f.name, f.number)
+			}
+			buf.WriteString("}\n")
+		}
+		if schema.keyType != "" {
+			buf.WriteString("\n")
+			writeProtoComment(&buf, "", schema.Name+".bytes, 按主键")
+			fmt.Fprintf(&buf, "message %vTable {\n", row.name)
+			fmt.Fprintf(&buf, "  map<%v, %v> Rows = 1;\n", schema.keyType, row.name)
+			buf.WriteString("}\n")
+		}
+		files = append(files, codeFile{name: schema.Name + ".proto", content: buf.Bytes()})
+	}
+	return files, nil
+}
+
+func writeProtoComment(buf *bytes.Buffer, indent, text string) {
+	if text == "" {
+		return
+	}
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		fmt.Fprintf(buf, "%v// %v\n", indent, strings.TrimSpace(line))
+	}
+}
+
+// 在json同一目录写出 MonsterConfig.bytes. 按主键的表编码为 MonsterConfigTable, 全局配置编码为一行
+func (t *TableData) exportProtoBytes(dir, target string, parsedData map[interface{}]map[string]interface{}) error {
+	if protoFields == nil {
+		return nil
+	}
+	schema := t.schema(target)
+	row := protoFields.tableMessages(schema)[0]
+	var buf bytes.Buffer
+	if schema.keyType == "" {
+		for _, v := range parsedData {
+			if err := encodeProtoMessage(&buf, row, v); err != nil {
+				return err
+			}
+		}
+	} else {
+		keys := make([]interface{}, 0, len(parsedData))
+		for k := range parsedData {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return protoKeyLess(keys[i], keys[j])
+		})
+		keyField := &protoField{number: 1, node: &SchemaNode{kind: Schema_Value, valueType: schema.keyType}}
+		for _, k := range keys {
+			var entry bytes.Buffer
+			if err := encodeProtoValue(&entry, keyField, k); err != nil {
+				return err
+			}
+			var value bytes.Buffer
+			if err := encodeProtoMessage(&value, row, parsedData[k]); err != nil {
+				return fmt.Errorf("encode %v %v. %v", t.sheet.Name, k, err)
+			}
+			appendProtoBytes(&entry, 2, value.Bytes())
+			appendProtoBytes(&buf, 1, entry.Bytes())
+		}
+	}
+	return os.WriteFile(filepath.Join(dir, t.sheet.Name+".bytes"), buf.Bytes(), 0644)
+}
+
+func protoKeyLess(a, b interface{}) bool {
+	switch a := a.(type) {
+	case int32:
+		if b, ok := b.(int32); ok {
+			return a < b
+		}
+	case int64:
+		if b, ok := b.(int64); ok {
+			return a < b
+		}
+	}
+	return fmt.Sprint(a) < fmt.Sprint(b)
+}
+
+func encodeProtoMessage(buf *bytes.Buffer, msg *protoMessage, value map[string]interface{}) error {
+	for _, f := range msg.fields {
+		v, ok := value[f.node.name]
+		if !ok {
+			continue
+		}
+		if err := encodeProtoField(buf, f, v); err != nil {
+			return fmt.Errorf("%v: %v", f.node.name, err)
+		}
+	}
+	return nil
+}
+
+// 数组的值为 *[]interface{}, 导出为名字数组的flags为 []interface{}
+func protoArray(v interface{}) ([]interface{}, bool) {
+	switch v := v.(type) {
+	case *[]interface{}:
+		return *v, true
+	case []interface{}:
+		return v, true
+	}
+	return nil, false
+}
+
+func encodeProtoField(buf *bytes.Buffer, f *protoField, v interface{}) error {
+	switch {
+	case f.node.kind == Schema_Arr:
+		arr, ok := protoArray(v)
+		if !ok {
+			return fmt.Errorf("%v is not an array", v)
+		}
+		if len(arr) == 0 {
+			return nil
+		}
+		elem := &protoField{number: f.number, node: f.node.elem, message: f.message}
+		if f.packed {
+			var packed bytes.Buffer
+			for _, item := range arr {
+				if err := encodeProtoScalar(&packed, elem.node.valueType, item); err != nil {
+					return err
+				}
+			}
+			appendProtoBytes(buf, f.number, packed.Bytes())
+			return nil
+		}
+		for _, item := range arr {
+			if f.wrapper != nil {
+				var wrapped bytes.Buffer
+				if err := encodeProtoField(&wrapped, f.wrapper.fields[0], item); err != nil {
+					return err
+				}
+				appendProtoBytes(buf, f.number, wrapped.Bytes())
+				continue
+			}
+			if err := encodeProtoField(buf, elem, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	case f.node.kind == Schema_Msg:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%v is not a message", v)
+		}
+		var sub bytes.Buffer
+		if err := encodeProtoMessage(&sub, f.message, m); err != nil {
+			return err
+		}
+		appendProtoBytes(buf, f.number, sub.Bytes())
+		return nil
+	case f.node.kind == Schema_Map:
+		dict, ok := v.(validator.Map)
+		if !ok {
+			return fmt.Errorf("%v is not a map", v)
+		}
+		keys := make([]string, 0, len(dict))
+		for k := range dict {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		keyType, valueType := scalarType(f.node.keyType), scalarType(f.node.valueType)
+		for _, k := range keys {
+			// 字典的key在json中为字符串
+			key, err := protoMapKey(k, keyType)
+			if err != nil {
+				return err
+			}
+			var entry bytes.Buffer
+			if err := encodeProtoValue(&entry, &protoField{number: 1, node: &SchemaNode{valueType: keyType}}, key); err != nil {
+				return err
+			}
+			if err := encodeProtoValue(&entry, &protoField{number: 2, node: &SchemaNode{valueType: valueType}}, dict[k]); err != nil {
+				return err
+			}
+			appendProtoBytes(buf, f.number, entry.Bytes())
+		}
+		return nil
+	default:
+		return encodeProtoValue(buf, f, v)
+	}
+}
+
+func protoMapKey(k, keyType string) (interface{}, error) {
+	switch keyType {
+	case "int32":
+		v, err := strconv.ParseInt(k, 10, 32)
+		return int32(v), err
+	case "int64":
+		return strconv.ParseInt(k, 10, 64)
+	default:
+		return k, nil
+	}
+}
+
+// 带tag的普通值
+func encodeProtoValue(buf *bytes.Buffer, f *protoField, v interface{}) error {
+	valueType := scalarType(f.node.valueType)
+	switch valueType {
+	case "string":
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("%v is not a string", v)
+		}
+		appendProtoBytes(buf, f.number, []byte(s))
+		return nil
+	case "float":
+		appendProtoVarint(buf, uint64(f.number)<<3|5)
+	case "double":
+		appendProtoVarint(buf, uint64(f.number)<<3|1)
+	default:
+		appendProtoVarint(buf, uint64(f.number)<<3)
+	}
+	return encodeProtoScalar(buf, valueType, v)
+}
+
+// 不带tag的数值, 用于普通值和packed数组
+func encodeProtoScalar(buf *bytes.Buffer, valueType string, v interface{}) error {
+	switch v := v.(type) {
+	case int32:
+		appendProtoVarint(buf, uint64(int64(v)))
+	case int64:
+		appendProtoVarint(buf, uint64(v))
+	case bool:
+		if v {
+			appendProtoVarint(buf, 1)
+		} else {
+			appendProtoVarint(buf, 0)
+		}
+	case float32:
+		var b [4]byte
+		binary.LittleEndian.PutUint32(b[:], math.Float32bits(v))
+		buf.Write(b[:])
+	case float64:
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(v))
+		buf.Write(b[:])
+	default:
+		return fmt.Errorf("%v is not a %v", v, scalarType(valueType))
+	}
+	return nil
+}
+
+func appendProtoVarint(buf *bytes.Buffer, v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	buf.Write(b[:n])
+}
+
+// length-delimited 的字段, 字符串, 子消息和packed数组
+func appendProtoBytes(buf *bytes.Buffer, number int, data []byte) {
+	appendProtoVarint(buf, uint64(number)<<3|2)
+	appendProtoVarint(buf, uint64(len(data)))
+	buf.Write(data)
+}
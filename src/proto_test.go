@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tealeg/xlsx/v3"
+)
+
+// wire type, 和 encodeProtoValue 中写出的一致
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+type wireValue struct {
+	typ int
+	v   uint64
+	b   []byte
+}
+
+func consumeVarint(t *testing.T, b []byte) (uint64, []byte) {
+	t.Helper()
+	v, n := binary.Uvarint(b)
+	if n <= 0 {
+		t.Fatalf("invalid varint %x", b)
+	}
+	return v, b[n:]
+}
+
+// 解码一个message的所有字段, 按编号分组
+func decodeWire(t *testing.T, b []byte) map[int][]wireValue {
+	t.Helper()
+	fields := map[int][]wireValue{}
+	for len(b) > 0 {
+		var tag uint64
+		tag, b = consumeVarint(t, b)
+		num, v := int(tag>>3), wireValue{typ: int(tag & 7)}
+		switch v.typ {
+		case wireVarint:
+			v.v, b = consumeVarint(t, b)
+		case wireFixed32:
+			if len(b) < 4 {
+				t.Fatalf("field %v: truncated fixed32", num)
+			}
+			v.v, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+		case wireFixed64:
+			if len(b) < 8 {
+				t.Fatalf("field %v: truncated fixed64", num)
+			}
+			v.v, b = binary.LittleEndian.Uint64(b), b[8:]
+		case wireBytes:
+			var n uint64
+			n, b = consumeVarint(t, b)
+			if uint64(len(b)) < n {
+				t.Fatalf("field %v: truncated bytes", num)
+			}
+			v.b, b = b[:n], b[n:]
+		default:
+			t.Fatalf("field %v: unexpected wire type %v", num, v.typ)
+		}
+		fields[num] = append(fields[num], v)
+	}
+	return fields
+}
+
+func decodePacked(t *testing.T, b []byte) []int64 {
+	t.Helper()
+	values := []int64{}
+	for len(b) > 0 {
+		var v uint64
+		v, b = consumeVarint(t, b)
+		values = append(values, int64(v))
+	}
+	return values
+}
+
+func writeTestWorkbook(t *testing.T, filename, sheetName string, rows [][]string) {
+	t.Helper()
+	wb := xlsx.NewFile()
+	sheet, err := wb.AddSheet(sheetName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, values := range rows {
+		row := sheet.AddRow()
+		for _, v := range values {
+			row.AddCell().SetString(v)
+		}
+	}
+	if err := wb.Save(filename); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExportProtoBytesRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "monster.xlsx")
+	writeTestWorkbook(t, filename, "MonsterConfig", [][]string{
+		{"##name", "Id", "Name", "Hp", "Speed", "Boss", "Tags[]", "Drop{ItemId", "Num}", "Attr", "Grid[[]]"},
+		{"##type", "int", "string", "int64", "float", "bool", "string", "int", "int", "map<string,int>", "int"},
+		{"", "1", "Slime", "-5", "1.5", "false", `[a,"b,c"]`, "1001", "2", "Atk:10;Def:5", "[[1,2],[3]]"},
+		{"", "2", "Dragon", "9007199254740993", "0.25", "true", "", "1002", "1", "", ""},
+	})
+
+	defer func(p *protoFieldNumbers) { protoFields = p }(protoFields)
+	var err error
+	if protoFields, err = loadProtoFieldNumbers(filepath.Join(dir, "proto")); err != nil {
+		t.Fatal(err)
+	}
+	tables, err := ConvertFiles([]string{filename})
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(dir, "out")
+	if err := os.MkdirAll(output, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	protoFields.assign(tables)
+	if err := ExportTables(tables, output, nil); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(output, "MonsterConfig.bytes"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	number := func(message, field string) int {
+		n, ok := protoFields.messages[message][field]
+		if !ok {
+			t.Fatalf("no field number for %v.%v", message, field)
+		}
+		return n
+	}
+	// MonsterConfigTable { map<int32, MonsterConfig> Rows = 1; }
+	rows := map[int32]map[int][]wireValue{}
+	for _, entry := range decodeWire(t, data)[1] {
+		e := decodeWire(t, entry.b)
+		rows[int32(e[1][0].v)] = decodeWire(t, e[2][0].b)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("got %v rows, want 2", len(rows))
+	}
+
+	row := rows[1]
+	if v := row[number("MonsterConfig", "Id")]; len(v) != 1 || v[0].v != 1 {
+		t.Errorf("Id: got %v", v)
+	}
+	if v := row[number("MonsterConfig", "Name")]; len(v) != 1 || string(v[0].b) != "Slime" {
+		t.Errorf("Name: got %v", v)
+	}
+	if v := row[number("MonsterConfig", "Hp")]; len(v) != 1 || int64(v[0].v) != -5 {
+		t.Errorf("Hp: got %v", v)
+	}
+	if v := row[number("MonsterConfig", "Speed")]; len(v) != 1 || v[0].typ != wireFixed32 || math.Float32frombits(uint32(v[0].v)) != 1.5 {
+		t.Errorf("Speed: got %v", v)
+	}
+	if v := row[number("MonsterConfig", "Boss")]; len(v) == 1 && v[0].v != 0 {
+		t.Errorf("Boss: got %v", v)
+	}
+	if v := row[number("MonsterConfig", "Tags")]; len(v) != 2 || string(v[0].b) != "a" || string(v[1].b) != "b,c" {
+		t.Errorf("Tags: got %v", v)
+	}
+	if v := row[number("MonsterConfig", "Drop")]; len(v) != 1 {
+		t.Errorf("Drop: got %v", v)
+	} else {
+		drop := decodeWire(t, v[0].b)
+		if drop[number("MonsterConfigDrop", "ItemId")][0].v != 1001 || drop[number("MonsterConfigDrop", "Num")][0].v != 2 {
+			t.Errorf("Drop: got %v", drop)
+		}
+	}
+	attr := map[string]uint64{}
+	for _, entry := range row[number("MonsterConfig", "Attr")] {
+		e := decodeWire(t, entry.b)
+		attr[string(e[1][0].b)] = e[2][0].v
+	}
+	if len(attr) != 2 || attr["Atk"] != 10 || attr["Def"] != 5 {
+		t.Errorf("Attr: got %v", attr)
+	}
+	// 数组的数组, 元素为只有一个字段 Items = 1 的message
+	grid := [][]int64{}
+	for _, item := range row[number("MonsterConfig", "Grid")] {
+		grid = append(grid, decodePacked(t, decodeWire(t, item.b)[1][0].b))
+	}
+	if len(grid) != 2 || len(grid[0]) != 2 || grid[0][1] != 2 || len(grid[1]) != 1 || grid[1][0] != 3 {
+		t.Errorf("Grid: got %v", grid)
+	}
+
+	row = rows[2]
+	if v := row[number("MonsterConfig", "Hp")]; len(v) != 1 || int64(v[0].v) != 9007199254740993 {
+		t.Errorf("Hp: got %v", v)
+	}
+	if v := row[number("MonsterConfig", "Speed")]; len(v) != 1 || math.Float32frombits(uint32(v[0].v)) != 0.25 {
+		t.Errorf("Speed: got %v", v)
+	}
+	if v := row[number("MonsterConfig", "Boss")]; len(v) != 1 || v[0].v != 1 {
+		t.Errorf("Boss: got %v", v)
+	}
+	for _, field := range []string{"Tags", "Attr", "Grid"} {
+		if v := row[number("MonsterConfig", field)]; len(v) != 0 {
+			t.Errorf("%v: expect empty, got %v", field, v)
+		}
+	}
+}
+
+func TestProtoFieldNumbersStable(t *testing.T) {
+	message := func(names ...string) *SchemaNode {
+		node := &SchemaNode{kind: Schema_Msg}
+		for _, name := range names {
+			node.fields = append(node.fields, &SchemaNode{kind: Schema_Value, name: name, valueType: "int"})
+		}
+		return node
+	}
+	numbers := func(msg *protoMessage) map[string]int {
+		res := map[string]int{}
+		for _, f := range msg.fields {
+			res[f.name] = f.number
+		}
+		return res
+	}
+	// 每次生成都从 fieldnumbers.json 中重新读取
+	build := func(dir string, node *SchemaNode) *protoMessage {
+		p, err := loadProtoFieldNumbers(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		messages := []*protoMessage{}
+		msg := p.buildMessage("Monster", node, &messages)
+		f, err := p.file()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(f.name, f.content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+	dir := t.TempDir()
+
+	msg := build(dir, message("Id", "Name", "Hp"))
+	if got := numbers(msg); got["Id"] != 1 || got["Name"] != 2 || got["Hp"] != 3 || len(msg.reserved) != 0 {
+		t.Fatalf("initial numbers: got %v reserved %v", got, msg.reserved)
+	}
+	// 删除 Name 加入 Lv, Lv 不能使用 Name 的编号
+	msg = build(dir, message("Id", "Hp", "Lv"))
+	if got := numbers(msg); got["Id"] != 1 || got["Hp"] != 3 || got["Lv"] != 4 {
+		t.Errorf("after removing Name: got %v", got)
+	}
+	if len(msg.reserved) != 1 || msg.reserved[0] != 2 {
+		t.Errorf("after removing Name: reserved %v, want [2]", msg.reserved)
+	}
+	// 重新加入 Name 使用原来的编号, 新字段使用新的编号
+	msg = build(dir, message("Id", "Name", "Hp", "Lv", "Mp"))
+	if got := numbers(msg); got["Name"] != 2 || got["Lv"] != 4 || got["Mp"] != 5 || len(msg.reserved) != 0 {
+		t.Errorf("after adding Name back: got %v reserved %v", got, msg.reserved)
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	json "github.com/json-iterator/go"
+	"github.com/laozhuzz/excel2json/validator"
+)
+
+//
+// 错误收集. 默认遇到第一个错误就停止,
+// keepGoing 时继续转换所有文件/sheet/行/规则, 最后统一按 文件/sheet/规则 分组输出.
+// 也可以输出为json和JUnit XML, 方便CI展示
+//
+
+var errTooManyErrors = errors.New("too many errors")
+
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+type ReportItem struct {
+	File     string `json:"file"`
+	Sheet    string `json:"sheet"`
+	Cell     string `json:"cell"`
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// 带位置的错误信息, 比如 excel/monster.xlsx!MonsterConfig!F12 Name: value not set
+func (item *ReportItem) String() string {
+	switch {
+	case item.Cell != "":
+		return item.File + "!" + item.Sheet + "!" + item.Cell + " " + item.Message
+	case item.Sheet != "":
+		return item.File + "!" + item.Sheet + " " + item.Message
+	default:
+		return item.File + " " + item.Message
+	}
+}
+
+type reportSheet struct {
+	file  string
+	sheet string
+}
+
+type ErrorReport struct {
+	keepGoing bool
+	// 最多收集的错误数量, 0 不限制
+	maxErrors int
+	items     []*ReportItem
+	errCount  int
+	// 转换过的sheet, 用于JUnit中没有错误的sheet
+	sheets []reportSheet
+}
+
+var errReport = &ErrorReport{}
+
+// 记录错误, 返回非空时需要停止转换
+func (r *ErrorReport) Add(file, sheet, rule string, err error) error {
+	r.add(file, sheet, rule, SeverityError, err)
+	r.errCount++
+	if !r.keepGoing {
+		return err
+	}
+	if r.maxErrors > 0 && r.errCount >= r.maxErrors {
+		return errTooManyErrors
+	}
+	return nil
+}
+
+// 记录警告, 不影响转换
+func (r *ErrorReport) Warn(file, sheet string, err error) {
+	r.add(file, sheet, "", SeverityWarning, err)
+}
+
+func (r *ErrorReport) add(file, sheet, rule, severity string, err error) {
+	item := &ReportItem{
+		File:     file,
+		Sheet:    sheet,
+		Rule:     rule,
+		Severity: severity,
+		Message:  err.Error(),
+	}
+	var ruleErr *validator.RuleError
+	var cellErr *validator.CellError
+	if errors.As(err, &ruleErr) {
+		item.Cell = ruleErr.Pos.Cell()
+		item.Message = ruleErr.Message()
+	} else if errors.As(err, &cellErr) {
+		item.Cell = cellErr.Pos.Cell()
+		item.Message = cellErr.Err.Error()
+	}
+	r.items = append(r.items, item)
+}
+
+func (r *ErrorReport) AddSheet(file, sheet string) {
+	r.sheets = append(r.sheets, reportSheet{file: file, sheet: sheet})
+}
+
+// 错误数量, 不包括警告
+func (r *ErrorReport) Count() int {
+	return r.errCount
+}
+
+func (r *ErrorReport) Empty() bool {
+	return len(r.items) == 0
+}
+
+// 按 文件/sheet/规则 分组输出, 组的顺序按第一次出现的顺序
+func (r *ErrorReport) Print() {
+	fmt.Printf("%v errors, %v warnings:\n", r.errCount, len(r.items)-r.errCount)
+	files, fileItems := groupReportItems(r.items, func(item *ReportItem) string { return item.File })
+	for _, file := range files {
+		fmt.Printf("%v\n", file)
+		sheets, sheetItems := groupReportItems(fileItems[file], func(item *ReportItem) string { return item.Sheet })
+		for _, sheet := range sheets {
+			fmt.Printf("  %v\n", sheet)
+			rules, ruleItems := groupReportItems(sheetItems[sheet], func(item *ReportItem) string { return item.Rule })
+			for _, rule := range rules {
+				if rule == "" {
+					fmt.Printf("    [parse]\n")
+				} else {
+					fmt.Printf("    [%v]\n", rule)
+				}
+				for _, item := range ruleItems[rule] {
+					fmt.Printf("      %v: %v\n", item.Severity, item)
+				}
+			}
+		}
+	}
+	if r.maxErrors > 0 && r.errCount >= r.maxErrors {
+		fmt.Printf("too many errors, stopped after %v\n", r.maxErrors)
+	}
+}
+
+func groupReportItems(items []*ReportItem, key func(*ReportItem) string) ([]string, map[string][]*ReportItem) {
+	keys := []string{}
+	groups := map[string][]*ReportItem{}
+	for _, item := range items {
+		k := key(item)
+		if _, ok := groups[k]; !ok {
+			keys = append(keys, k)
+		}
+		groups[k] = append(groups[k], item)
+	}
+	return keys, groups
+}
+
+// 输出 path.json 和 path.xml(JUnit)
+func (r *ErrorReport) Write(path string) error {
+	items := r.items
+	if items == nil {
+		items = []*ReportItem{}
+	}
+	data, err := json.MarshalIndent(items, "", " ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".json", data, 0644); err != nil {
+		return err
+	}
+	data, err = xml.MarshalIndent(r.junit(), "", " ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path+".xml", append([]byte(xml.Header), data...), 0644)
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name          `xml:"testsuites"`
+	Name     string            `xml:"name,attr"`
+	Tests    int               `xml:"tests,attr"`
+	Failures int               `xml:"failures,attr"`
+	Suites   []*junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Cases    []*junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// 每个sheet一个testsuite, 每个出错的单元格一个失败的testcase, 警告为成功的testcase,
+// 没有错误和警告的sheet一个成功的testcase
+func (r *ErrorReport) junit() *junitTestSuites {
+	root := &junitTestSuites{Name: "excel2json"}
+	suites := map[reportSheet]*junitTestSuite{}
+	getSuite := func(file, sheet string) *junitTestSuite {
+		key := reportSheet{file: file, sheet: sheet}
+		if suite, ok := suites[key]; ok {
+			return suite
+		}
+		name := file
+		if sheet != "" {
+			name = file + "!" + sheet
+		}
+		suite := &junitTestSuite{Name: name}
+		suites[key] = suite
+		root.Suites = append(root.Suites, suite)
+		return suite
+	}
+	for _, s := range r.sheets {
+		getSuite(s.file, s.sheet)
+	}
+	for _, item := range r.items {
+		suite := getSuite(item.File, item.Sheet)
+		rule := item.Rule
+		if rule == "" {
+			rule = "parse"
+		}
+		name := rule
+		if item.Cell != "" {
+			name = item.Cell + " " + rule
+		}
+		testCase := &junitTestCase{
+			ClassName: suite.Name,
+			Name:      name,
+		}
+		if item.Severity == SeverityError {
+			testCase.Failure = &junitFailure{
+				Message: item.Message,
+				Type:    item.Severity,
+				Text:    item.String(),
+			}
+			suite.Failures++
+		} else {
+			testCase.SystemOut = item.Severity + ": " + item.String()
+		}
+		suite.Cases = append(suite.Cases, testCase)
+	}
+	for _, suite := range root.Suites {
+		if len(suite.Cases) == 0 {
+			suite.Cases = append(suite.Cases, &junitTestCase{ClassName: suite.Name, Name: "convert"})
+		}
+		suite.Tests = len(suite.Cases)
+		root.Tests += suite.Tests
+		root.Failures += suite.Failures
+	}
+	return root
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+//
+// 表结构. 由表头的 NestedFieldDesc 构建, 和 parseRowData 得到的数据结构一致,
+// 用于生成各语言的代码. 导出目标过滤掉的列不在结构中
+//
+
+type SchemaKind uint8
+
+const (
+	Schema_Value = SchemaKind(0)
+	Schema_Arr   = SchemaKind(1)
+	Schema_Msg   = SchemaKind(2)
+	Schema_Map   = SchemaKind(3)
+)
+
+type SchemaNode struct {
+	kind SchemaKind
+	// 子消息中的字段名, 数组元素为空
+	name string
+	// 普通值的类型, 字典为value的类型
+	valueType string
+	// 字典key的类型
+	keyType string
+	// ##desc
+	desc string
+	// 数组的元素
+	elem *SchemaNode
+	// 子消息的字段, 按列顺序
+	fields []*SchemaNode
+	// 定义该字段的列, 跨列的数组/子消息为第一列
+	col int
+	// 普通值和字典的列是否导出
+	exported bool
+	// 子消息中的字段是否总是有值, 和 parseRowData 中空值的规则一致
+	required bool
+	// 普通值上的规则, 字典为value上的规则, 比如 range=[1,100]
+	rules []string
+}
+
+// 一个表导出的结构
+type TableSchema struct {
+	// sheet名, 也是导出的json文件名
+	Name string
+	root *SchemaNode
+	// 主键的类型, 多主键为string, 单行配置 (竖排的全局配置, 只有一行主键为0的表) 为空
+	keyType string
+	table   *TableData
+}
+
+// 按目标构建表结构, target为空时包含所有列
+func (t *TableData) schema(target string) *TableSchema {
+	root := &SchemaNode{kind: Schema_Msg, name: t.sheet.Name}
+	descRow := t.header["##desc"]
+	validatorRow := t.header["##validator"]
+	stack := []*SchemaNode{root}
+	// 字典中已读取的列数, 列依次为 key, value, key, value...
+	mapIndex := map[*SchemaNode]int{}
+	for coli := 1; coli < len(t.rowDesc); coli++ {
+		desc := t.rowDesc[coli]
+		if desc.ignored() {
+			continue
+		}
+		exported := t.isColumnExported(coli, target)
+		text := ""
+		if descRow != nil && coli < len(descRow.Fields) {
+			text = strings.TrimSpace(descRow.Fields[coli])
+		}
+		rule := ""
+		if validatorRow != nil && coli < len(validatorRow.Fields) && !strings.HasPrefix(validatorRow.Fields[coli], "##") {
+			rule = strings.TrimSpace(validatorRow.Fields[coli])
+		}
+		for _, v := range desc.NestedField {
+			cur := stack[len(stack)-1]
+			switch v.state {
+			case State_SetArr:
+				// Tags[] 在 State_ArrBegin 中已经是数组, 这里和普通值一样为数组的元素
+				fallthrough
+			case State_Set:
+				if cur.kind == Schema_Map {
+					if mapIndex[cur]%2 == 0 {
+						cur.keyType = desc.ValueType
+					} else {
+						cur.valueType = desc.ValueType
+						cur.exported = cur.exported || exported
+						cur.addRule(rule)
+					}
+					mapIndex[cur]++
+					continue
+				}
+				node := cur.child(v.name, valueSchema(desc.ValueType), coli, text)
+				if exported {
+					node.setExported()
+				}
+				node.addRule(rule)
+				// 数组中的值和数组中的子消息的字段可以为空, 字符串和字典空值不导出, flags 空值为0
+				inArray := cur.kind == Schema_Arr || len(stack) > 1 && stack[len(stack)-2].kind == Schema_Arr
+				if !inArray && desc.ValueType != "string" && !isMapType(desc.ValueType) {
+					node.required = true
+				}
+			case State_SetInline:
+				node := cur.child(v.name, inlineSchema(v.inline), coli, text)
+				if exported {
+					node.setExported()
+				}
+				// 可以指定检查的字段, 比如 ItemId:ref=ItemConfig.Id
+				if pos := strings.Index(rule, ":"); pos >= 0 && pos < strings.Index(rule, "=") {
+					node = node.find(strings.Split(rule[:pos], "."))
+					rule = rule[pos+1:]
+				}
+				if node != nil {
+					node.addRule(rule)
+				}
+			case State_ArrBegin:
+				// 跨列的数组总是导出, 可以为空数组
+				stack = append(stack, cur.child(v.name, &SchemaNode{kind: Schema_Arr, required: true}, coli, text))
+			case State_MsgBegin:
+				stack = append(stack, cur.child(v.name, &SchemaNode{kind: Schema_Msg}, coli, text))
+			case State_MapBegin:
+				stack = append(stack, cur.child(v.name, &SchemaNode{kind: Schema_Map}, coli, text))
+			case State_ArrEnd:
+				fallthrough
+			case State_MsgEnd:
+				fallthrough
+			case State_MapEnd:
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+	root.prune()
+	for _, f := range root.fields {
+		for _, k := range t.keyFields {
+			if f.name == k {
+				f.required = true
+			}
+		}
+	}
+
+	schema := &TableSchema{Name: t.sheet.Name, root: root, table: t}
+	if !t.isSingleObject() {
+		schema.keyType = "string"
+		if len(t.keyFields) == 1 {
+			for _, f := range root.fields {
+				if f.name == t.keyFields[0] {
+					schema.keyType = scalarType(f.valueType)
+				}
+			}
+		}
+	}
+	return schema
+}
+
+// 普通值的结构, 单元格中的字典和导出为名字数组的flags为对应的结构
+func valueSchema(valueType string) *SchemaNode {
+	if kind, arg, ok := parseGenericType(valueType); ok {
+		switch kind {
+		case "map":
+			keyType, valueType, _ := splitMapType(arg)
+			return &SchemaNode{kind: Schema_Map, keyType: keyType, valueType: valueType}
+		case "flags":
+			if flagsAsNames {
+				return &SchemaNode{kind: Schema_Arr, elem: &SchemaNode{kind: Schema_Value, valueType: "string"}}
+			}
+		}
+	}
+	return &SchemaNode{kind: Schema_Value, valueType: valueType}
+}
+
+// 单元格中的数组和子消息的结构
+func inlineSchema(desc *InlineDesc) *SchemaNode {
+	switch desc.kind {
+	case Inline_Arr:
+		return &SchemaNode{kind: Schema_Arr, elem: inlineSchema(desc.elem)}
+	case Inline_Msg:
+		node := &SchemaNode{kind: Schema_Msg}
+		for _, f := range desc.fields {
+			field := inlineSchema(f)
+			field.name = f.name
+			node.fields = append(node.fields, field)
+		}
+		return node
+	default:
+		return valueSchema(desc.valueType)
+	}
+}
+
+// 在当前的数组或者子消息中加入字段, 已经有同名的字段时沿用, 比如数组中的多个子消息
+func (n *SchemaNode) child(name string, node *SchemaNode, col int, desc string) *SchemaNode {
+	if n.kind == Schema_Arr {
+		if n.elem != nil && n.elem.kind == node.kind {
+			return n.elem
+		}
+		n.elem = node
+	} else {
+		for _, f := range n.fields {
+			if f.name == name && f.kind == node.kind {
+				return f
+			}
+		}
+		node.name = name
+		n.fields = append(n.fields, node)
+	}
+	node.col, node.desc = col, desc
+	return node
+}
+
+// 规则检查数组中的每个值, 规则只记录在普通值和字典上
+func (n *SchemaNode) addRule(rule string) {
+	for n.kind == Schema_Arr && n.elem != nil {
+		n = n.elem
+	}
+	if rule == "" || n.kind == Schema_Msg {
+		return
+	}
+	for _, r := range n.rules {
+		if r == rule {
+			return
+		}
+	}
+	n.rules = append(n.rules, rule)
+}
+
+// 子消息中按字段路径查找, 经过的数组查找数组的元素
+func (n *SchemaNode) find(path []string) *SchemaNode {
+	for _, name := range path {
+		for n.kind == Schema_Arr && n.elem != nil {
+			n = n.elem
+		}
+		var found *SchemaNode
+		for _, f := range n.fields {
+			if f.name == strings.TrimSpace(name) {
+				found = f
+			}
+		}
+		if found == nil {
+			return nil
+		}
+		n = found
+	}
+	return n
+}
+
+// 标记普通值和字典导出, 数组和子消息标记所有的子字段
+func (n *SchemaNode) setExported() {
+	switch n.kind {
+	case Schema_Arr:
+		if n.elem != nil {
+			n.elem.setExported()
+		}
+	case Schema_Msg:
+		for _, f := range n.fields {
+			f.setExported()
+		}
+	default:
+		n.exported = true
+	}
+}
+
+// 去掉没有导出的字段, 子字段全部没有导出的数组和子消息也去掉. 返回是否有导出的字段
+func (n *SchemaNode) prune() bool {
+	switch n.kind {
+	case Schema_Arr:
+		return n.elem != nil && n.elem.prune()
+	case Schema_Msg:
+		fields := n.fields[:0]
+		for _, f := range n.fields {
+			if f.prune() {
+				fields = append(fields, f)
+			}
+		}
+		n.fields = fields
+		// 空的子消息不导出, 有总是有值的字段时子消息也总是有值
+		for _, f := range fields {
+			n.required = n.required || f.required
+		}
+		return len(fields) > 0
+	default:
+		return n.exported
+	}
+}
+
+// 普通值导出到json中的类型: string int32 int64 float double bool
+func scalarType(valueType string) string {
+	if valueType == "int" {
+		return "int32"
+	}
+	if kind, name, ok := parseGenericType(valueType); ok {
+		switch kind {
+		case "enum":
+			if enums[name] != nil && enums[name].isString {
+				return "string"
+			}
+			return "int32"
+		case "flags":
+			return "int32"
+		}
+	}
+	return valueType
+}
+
+// 字段名转为代码中的名字, 首字母大写, 不能作为标识符的字符替换为_
+func exportedName(name string) string {
+	runes := []rune(name)
+	for i, r := range runes {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
+			runes[i] = '_'
+		}
+	}
+	if len(runes) == 0 || !unicode.IsLetter(runes[0]) {
+		return "F" + string(runes)
+	}
+	if !unicode.IsUpper(runes[0]) {
+		if upper := unicode.ToUpper(runes[0]); upper != runes[0] {
+			runes[0] = upper
+		} else {
+			// 没有大小写的字符, 比如中文
+			return "F" + string(runes)
+		}
+	}
+	return string(runes)
+}
+
+type codeFile struct {
+	name    string
+	content []byte
+}
+
+// 代码生成, 每种语言一个实现
+type codeGenerator interface {
+	generate(schemas []*TableSchema) ([]codeFile, error)
+}
+
+type codeOutput struct {
+	dir string
+	gen codeGenerator
+}
+
+// targets为空时生成所有列到dir, 否则每个目标生成到dir下同名目录. 返回的文件名包含目录
+func GenerateCode(tables []*TableData, dir string, targets []string, gen codeGenerator) ([]codeFile, error) {
+	if len(targets) == 0 {
+		targets = []string{""}
+	}
+	files := []codeFile{}
+	for _, target := range targets {
+		schemas := make([]*TableSchema, 0, len(tables))
+		for _, tableData := range tables {
+			schemas = append(schemas, tableData.schema(target))
+		}
+		generated, err := gen.generate(schemas)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range generated {
+			files = append(files, codeFile{name: filepath.Join(dir, target, f.name), content: f.content})
+		}
+	}
+	return files, nil
+}
+
+// 一个目录中生成的文件. 先写到同目录的临时文件, 全部写出后再替换.
+// 只替换生成的文件, 目录中的其他文件保持不变
+type stagedFiles struct {
+	dir   string
+	files []codeFile
+	tmps  []string
+}
+
+func (s *stagedFiles) stage() error {
+	for _, f := range s.files {
+		dir, base := filepath.Split(f.name)
+		if dir == "" {
+			dir = "."
+		}
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			s.discard()
+			return err
+		}
+		tmp, err := os.CreateTemp(dir, base+".tmp")
+		if err != nil {
+			s.discard()
+			return err
+		}
+		s.tmps = append(s.tmps, tmp.Name())
+		_, err = tmp.Write(f.content)
+		if cerr := tmp.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Chmod(tmp.Name(), 0644)
+		}
+		if err != nil {
+			s.discard()
+			return err
+		}
+	}
+	return nil
+}
+
+// 临时文件替换为生成的文件
+func (s *stagedFiles) commit() error {
+	for i, tmp := range s.tmps {
+		if err := os.Rename(tmp, s.files[i].name); err != nil {
+			s.tmps = s.tmps[i:]
+			s.discard()
+			return err
+		}
+	}
+	s.tmps = nil
+	return nil
+}
+
+// 删除没有替换的临时文件
+func (s *stagedFiles) discard() {
+	for _, tmp := range s.tmps {
+		os.Remove(tmp)
+	}
+	s.tmps = nil
+}
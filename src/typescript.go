@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+//
+// 生成TypeScript类型定义. 每个表一个 MonsterConfig.d.ts, 每行为一个interface, 子消息为单独命名的interface,
+// ##desc 为注释, 可能为空的字段为可选字段. int64 按参数导出为 string 或者 bigint
+//
+
+// int64 在json中导出为字符串. 生成 TypeScript 并且 int64 为 string 时打开, JSON.parse 读取不丢失精度
+var int64AsString = false
+
+type typescriptGenerator struct {
+	// int64 的类型, string 或者 bigint
+	int64Type string
+}
+
+func (g *typescriptGenerator) generate(schemas []*TableSchema) ([]codeFile, error) {
+	files := []codeFile{}
+	for _, schema := range schemas {
+		var buf bytes.Buffer
+		buf.WriteString("// Generated by excel2json. DO NOT EDIT.\n")
+		name := exportedName(schema.Name)
+		doc := schema.Name + ".json 中的一行"
+		if schema.keyType == "" {
+			doc = schema.Name + ".json 中的配置"
+		}
+		g.writeInterface(&buf, name, schema.root, doc)
+		// 全局配置没有表, json 即为 interface
+		if schema.keyType != "" {
+			fmt.Fprintf(&buf, "\n/** %v.json, 按主键 */\n", schema.Name)
+			fmt.Fprintf(&buf, "export type %vTable = Record<string, %v>;\n", name, name)
+		}
+		files = append(files, codeFile{name: schema.Name + ".d.ts", content: buf.Bytes()})
+	}
+	return files, nil
+}
+
+// 子消息的interface写在父interface之后, 名字为父interface名加字段名
+func (g *typescriptGenerator) writeInterface(buf *bytes.Buffer, name string, node *SchemaNode, doc string) {
+	type nested struct {
+		name string
+		node *SchemaNode
+		doc  string
+	}
+	subs := []nested{}
+	buf.WriteString("\n")
+	writeJSDoc(buf, "", strings.TrimSpace(name+" "+doc))
+	fmt.Fprintf(buf, "export interface %v {\n", name)
+	for _, f := range node.fields {
+		writeJSDoc(buf, "    ", f.desc)
+		typeName := g.typeOf(f, name+exportedName(f.name), func(sub *SchemaNode, subName string) {
+			subs = append(subs, nested{name: subName, node: sub, doc: f.desc})
+		})
+		optional := "?"
+		if f.required {
+			optional = ""
+		}
+		fmt.Fprintf(buf, "    %v%v: %v;\n", tsPropName(f.name), optional, typeName)
+	}
+	buf.WriteString("}\n")
+	for _, sub := range subs {
+		g.writeInterface(buf, sub.name, sub.node, sub.doc)
+	}
+}
+
+// 字段的类型, 子消息通过 nested 返回需要生成的interface
+func (g *typescriptGenerator) typeOf(node *SchemaNode, name string, nested func(*SchemaNode, string)) string {
+	switch node.kind {
+	case Schema_Arr:
+		return g.typeOf(node.elem, name, nested) + "[]"
+	case Schema_Msg:
+		nested(node, name)
+		return name
+	case Schema_Map:
+		// json 中字典的key总是字符串
+		return fmt.Sprintf("Record<string, %v>", g.scalarType(scalarType(node.valueType)))
+	default:
+		return g.scalarType(scalarType(node.valueType))
+	}
+}
+
+func (g *typescriptGenerator) scalarType(valueType string) string {
+	switch valueType {
+	case "int64":
+		return g.int64Type
+	case "int32":
+		fallthrough
+	case "float":
+		fallthrough
+	case "double":
+		return "number"
+	case "bool":
+		return "boolean"
+	default:
+		return valueType
+	}
+}
+
+// 字段名不是合法的标识符时加引号
+func tsPropName(name string) string {
+	for i, r := range name {
+		if !unicode.IsLetter(r) && r != '_' && r != '$' && (i == 0 || !unicode.IsDigit(r)) {
+			return strconv.Quote(name)
+		}
+	}
+	return name
+}
+
+func writeJSDoc(buf *bytes.Buffer, indent, text string) {
+	if text == "" {
+		return
+	}
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	if len(lines) == 1 {
+		fmt.Fprintf(buf, "%v/** %v */\n", indent, strings.ReplaceAll(strings.TrimSpace(lines[0]), "*/", "* /"))
+		return
+	}
+	fmt.Fprintf(buf, "%v/**\n", indent)
+	for _, line := range lines {
+		fmt.Fprintf(buf, "%v * %v\n", indent, strings.ReplaceAll(strings.TrimSpace(line), "*/", "* /"))
+	}
+	fmt.Fprintf(buf, "%v */\n", indent)
+}
@@ -0,0 +1,228 @@
+package validator
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// 单元格位置, Row Col 从0开始
+type CellPos struct {
+	File  string
+	Sheet string
+	Row   int
+	Col   int
+}
+
+// CellName excel风格的单元格名字, 比如 F12
+func CellName(row, col int) string {
+	name := ""
+	for col++; col > 0; col = (col - 1) / 26 {
+		name = string(rune('A'+(col-1)%26)) + name
+	}
+	return name + strconv.Itoa(row+1)
+}
+
+func (p CellPos) Cell() string {
+	return CellName(p.Row, p.Col)
+}
+
+// 比如 monster.xlsx!MonsterConfig!F12
+func (p CellPos) String() string {
+	return p.File + "!" + p.Sheet + "!" + p.Cell()
+}
+
+// 带单元格位置的错误
+type CellError struct {
+	Pos CellPos
+	Err error
+}
+
+func (e *CellError) Error() string {
+	return e.Pos.String() + " " + e.Err.Error()
+}
+
+func (e *CellError) Unwrap() error {
+	return e.Err
+}
+
+// 规则检查用的表数据, 保留每个值对应的单元格
+type Table struct {
+	Rows    map[interface{}]map[string]interface{}
+	Sources map[interface{}]*RowSource
+	// 主键字段
+	KeyFields []string
+	// 所有列的字段路径, 比如 Reward.ItemId
+	Columns []string
+	// 归一化的主键到原主键, 见 NormalizeKey
+	keys map[interface{}]interface{}
+	// 非主键列的索引, 外键指向非主键列时使用
+	indexes map[string]*columnIndex
+}
+
+type columnIndex struct {
+	// 归一化的值到所在行的主键
+	values map[interface{}]interface{}
+	err    error
+}
+
+type RowSource struct {
+	// 行的第一个单元格
+	Pos CellPos
+	// 值路径对应的单元格, 路径见 FieldPath IndexPath
+	Cells map[string]CellPos
+}
+
+// NormalizeKey 归一化主键, 整数统一为int64, 数字字符串也转为int64,
+// 使 int32/int64/string 类型的主键和外键可以互相比较
+func NormalizeKey(key interface{}) interface{} {
+	switch k := key.(type) {
+	case int32:
+		return int64(k)
+	case int64:
+		return k
+	case int:
+		return int64(k)
+	case string:
+		if v, err := strconv.ParseInt(k, 10, 64); err == nil && strconv.FormatInt(v, 10) == k {
+			return v
+		}
+		return k
+	}
+	return key
+}
+
+// 按归一化后的主键查找行, 返回表中的原主键
+func (t *Table) FindKey(key interface{}) (interface{}, bool) {
+	if _, ok := t.Rows[key]; ok {
+		return key, true
+	}
+	if t.keys == nil {
+		t.keys = make(map[interface{}]interface{}, len(t.Rows))
+		for k := range t.Rows {
+			t.keys[NormalizeKey(k)] = k
+		}
+	}
+	k, ok := t.keys[NormalizeKey(key)]
+	return k, ok
+}
+
+// 是否主键字段
+func (t *Table) IsKey(fields []string) bool {
+	return strings.Join(fields, ",") == strings.Join(t.KeyFields, ",")
+}
+
+// 按归一化后的值查找列中的值, 返回所在行的主键. 列中的非空值必须唯一
+func (t *Table) FindValue(path string, value interface{}) (interface{}, bool, error) {
+	if t.indexes == nil {
+		t.indexes = map[string]*columnIndex{}
+	}
+	index, ok := t.indexes[path]
+	if !ok {
+		index = t.buildIndex(path)
+		t.indexes[path] = index
+	}
+	if index.err != nil {
+		return nil, false, index.err
+	}
+	id, ok := index.values[NormalizeKey(value)]
+	return id, ok, nil
+}
+
+// 建立列的索引, 数组中的每个元素都加入索引. 0和空字符串不加入索引
+func (t *Table) buildIndex(path string) *columnIndex {
+	index := &columnIndex{values: map[interface{}]interface{}{}}
+	found := false
+	for _, c := range t.Columns {
+		if c == path {
+			found = true
+			break
+		}
+	}
+	if !found {
+		index.err = fmt.Errorf("column %v not exists", path)
+		return index
+	}
+	type valuePos struct {
+		id   interface{}
+		path string
+	}
+	first := map[interface{}]valuePos{}
+	for _, id := range sortedKeys(t) {
+		err := walkField(strings.Split(path, "."), t.Rows[id], "", func(p string, v interface{}) error {
+			if v == nil || reflect.ValueOf(v).IsZero() {
+				return nil
+			}
+			k := NormalizeKey(v)
+			if prev, ok := first[k]; ok {
+				if prev.id == id {
+					return nil
+				}
+				return fmt.Errorf("column %v value %v is not unique, defined at %v and %v", path, v, t.CellOf(prev.id, prev.path), t.CellOf(id, p))
+			}
+			first[k] = valuePos{id: id, path: p}
+			index.values[k] = id
+			return nil
+		})
+		if err != nil {
+			index.err = err
+			return index
+		}
+	}
+	return index
+}
+
+// 值所在的单元格. 路径是子消息或数组时返回其中第一个值的单元格,
+// 路径在单元格内部时(比如字典的值)返回所在的单元格, 找不到时返回行的位置
+func (t *Table) CellOf(id interface{}, path string) CellPos {
+	src := t.Sources[id]
+	if src == nil {
+		return CellPos{}
+	}
+	if pos, ok := src.Cells[path]; ok {
+		return pos
+	}
+	found := false
+	res := src.Pos
+	for p, pos := range src.Cells {
+		if path != "" && !strings.HasPrefix(p, path+".") && !strings.HasPrefix(p, path+"[") {
+			continue
+		}
+		if !found || pos.Row < res.Row || (pos.Row == res.Row && pos.Col < res.Col) {
+			res = pos
+			found = true
+		}
+	}
+	if found || path == "" {
+		return res
+	}
+	for p := parentPath(path); p != ""; p = parentPath(p) {
+		if pos, ok := src.Cells[p]; ok {
+			return pos
+		}
+	}
+	return res
+}
+
+// 上一级路径, Reward[1].ItemId 返回 Reward[1], Reward[1] 返回 Reward
+func parentPath(path string) string {
+	pos := strings.LastIndexAny(path, ".[")
+	if pos < 0 {
+		return ""
+	}
+	return path[:pos]
+}
+
+// FieldPath 子字段的路径, 比如 Shape.Radius
+func FieldPath(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
+
+// IndexPath 数组元素的路径, 比如 Reward[1]
+func IndexPath(parent string, i int) string {
+	return parent + "[" + strconv.Itoa(i) + "]"
+}
@@ -3,7 +3,8 @@ package validator
 import (
 	"errors"
 	"fmt"
-	"reflect"
+	"sort"
+	"strings"
 	"sync"
 )
 
@@ -12,6 +13,24 @@ var (
 	instance *Validator
 )
 
+// 多主键拼接时的分隔符
+const KeySeparator = "|"
+
+// JoinKey 将多主键的各列值拼接为一个字符串主键
+func JoinKey(values ...interface{}) string {
+	strs := make([]string, 0, len(values))
+	for _, v := range values {
+		strs = append(strs, fmt.Sprint(v))
+	}
+	return strings.Join(strs, KeySeparator)
+}
+
+// Map 字典类型的值, 和子消息区分. 规则检查时检查每个值
+type Map map[string]interface{}
+
+// 规则路径中表示检查字典的key, 比如 MonsterConfig.Drop.@key
+const MapKeyField = "@key"
+
 type IRuleHandler interface {
 	CheckRuleFormat(src, cmd, dest string) error
 	VerifyRule(v *Validator, rule Rule) error
@@ -21,12 +40,44 @@ type Rule struct {
 	cmd string
 	src string
 	dst string
+	// 规则所在的单元格
+	pos CellPos
+}
+
+func (r Rule) String() string {
+	return r.src + " " + r.cmd + "=" + r.dst
+}
+
+// 规则检查失败的错误, Id 为空时表示整条规则出错, Pos 为规则所在的单元格
+type RuleError struct {
+	Rule  Rule
+	Table string
+	Id    interface{}
+	Pos   CellPos
+	Err   error
+}
+
+func (e *RuleError) Error() string {
+	return e.Pos.String() + " " + e.Message()
+}
+
+// 不带单元格位置的错误信息
+func (e *RuleError) Message() string {
+	if e.Id == nil {
+		return fmt.Sprintf("table:%v %v fail. %v. err:%v", e.Table, e.Rule.cmd, e.Rule.src, e.Err)
+	}
+	return fmt.Sprintf("table:%v id:%v %v fail. %v. err:%v", e.Table, e.Id, e.Rule.cmd, e.Rule.src, e.Err)
 }
 
 type Validator struct {
 	rules       []Rule
 	ruleHandler map[string]IRuleHandler
-	tables      map[string]map[interface{}]map[string]interface{}
+	tables      map[string]*Table
+	// 资源根目录, path 规则检查的路径相对于该目录
+	assetRoot string
+	// 错误处理, 返回nil时继续检查; 为空时遇到第一个错误就停止
+	errorHandler func(*RuleError) error
+	stopErr      error
 }
 
 func Instance() *Validator {
@@ -34,17 +85,42 @@ func Instance() *Validator {
 		instance = &Validator{
 			rules:       []Rule{},
 			ruleHandler: map[string]IRuleHandler{},
-			tables:      map[string]map[interface{}]map[string]interface{}{},
+			tables:      map[string]*Table{},
 		}
 
 	})
 	return instance
 }
+func (v *Validator) SetAssetRoot(root string) {
+	v.assetRoot = root
+}
+
+func (v *Validator) SetErrorHandler(h func(*RuleError) error) {
+	v.errorHandler = h
+}
+
+// 规则检查失败时调用, path 为出错的值在行中的路径. 返回非空时需要停止检查
+func (v *Validator) Fail(rule Rule, table string, id interface{}, path string, err error) error {
+	e := &RuleError{Rule: rule, Table: table, Id: id, Pos: rule.pos, Err: err}
+	if t := v.tables[table]; t != nil && id != nil {
+		e.Pos = t.CellOf(id, path)
+	}
+	if v.errorHandler == nil {
+		v.stopErr = e
+		return e
+	}
+	if err := v.errorHandler(e); err != nil {
+		v.stopErr = err
+		return err
+	}
+	return nil
+}
+
 func (v *Validator) RegisterHandler(cmd string, h IRuleHandler) {
 	v.ruleHandler[cmd] = h
 }
 
-func (v *Validator) AddRule(src, cmd, dest string) error {
+func (v *Validator) AddRule(src, cmd, dest string, pos CellPos) error {
 	h := v.ruleHandler[cmd]
 	if h == nil {
 		return errors.New(cmd + " cmd not supported.")
@@ -52,18 +128,25 @@ func (v *Validator) AddRule(src, cmd, dest string) error {
 	if err := h.CheckRuleFormat(src, cmd, dest); err != nil {
 		return err
 	}
+	// 数组中每个元素的列都配置了同样的规则时只检查一次
+	for _, r := range v.rules {
+		if r.src == src && r.cmd == cmd && r.dst == dest {
+			return nil
+		}
+	}
 	v.rules = append(v.rules, Rule{
 		cmd: cmd,
 		src: src,
 		dst: dest,
+		pos: pos,
 	})
 	return nil
 }
-func (v *Validator) AddTableData(name string, parsedData map[interface{}]map[string]interface{}) {
+func (v *Validator) AddTableData(name string, table *Table) {
 	if v.tables == nil {
-		v.tables = make(map[string]map[interface{}]map[string]interface{})
+		v.tables = make(map[string]*Table)
 	}
-	v.tables[name] = parsedData
+	v.tables[name] = table
 }
 
 func (v *Validator) Validate() error {
@@ -72,7 +155,12 @@ func (v *Validator) Validate() error {
 	}
 	for i := 0; i < len(v.rules); i++ {
 		if err := v.verifyRule(v.rules[i]); err != nil {
-			return err
+			if v.stopErr != nil {
+				return v.stopErr
+			}
+			if err := v.Fail(v.rules[i], strings.Split(v.rules[i].src, ".")[0], nil, "", err); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
@@ -87,62 +175,99 @@ func (v *Validator) verifyRule(rule Rule) error {
 	return h.VerifyRule(v, rule)
 }
 
-func (v *Validator) GetFieldValue(fields []string, row map[string]interface{}) (interface{}, error) {
-	if fv, ok := row[fields[0]]; !ok {
-		return nil, errors.New("field " + fields[0] + " not exist")
-	} else {
-		if len(fields) > 1 {
-			cur := reflect.ValueOf(fv)
-			return getSubFieldValue(fields[1:], cur)
-		} else {
-			return fv, nil
+// 遍历规则字段在表中每一行的值, check 失败时按值所在的单元格记录错误
+func (v *Validator) verifyValues(rule Rule, fields []string, check func(interface{}) error) error {
+	table := v.tables[fields[0]]
+	if table == nil {
+		return errors.New("not found table data " + fields[0])
+	}
+	for _, id := range sortedKeys(table) {
+		err := walkField(fields[1:], table.Rows[id], "", func(path string, fv interface{}) error {
+			if err := check(fv); err != nil {
+				return v.Fail(rule, fields[0], id, path, err)
+			}
+			return nil
+		})
+		if err != nil {
+			if v.stopErr != nil {
+				return err
+			}
+			if err := v.Fail(rule, fields[0], id, "", err); err != nil {
+				return err
+			}
 		}
 	}
+	return nil
 }
 
-func getSubFieldValue(fields []string, fv reflect.Value) (interface{}, error) {
-	cur := fv
-	if cur.Kind() == reflect.Ptr {
-		cur = cur.Elem()
+// 表中所有主键, 按主键排序, 保证检查和报错的顺序稳定
+func sortedKeys(table *Table) []interface{} {
+	keys := make([]interface{}, 0, len(table.Rows))
+	for k := range table.Rows {
+		keys = append(keys, k)
 	}
-	if cur.Kind() == reflect.Interface {
-		cur = cur.Elem()
-	}
-	//fmt.Printf("%v:%v %v", fields, cur.Kind().String(), fv)
+	sort.Slice(keys, func(i, j int) bool {
+		a, aok := keys[i].(int32)
+		b, bok := keys[j].(int32)
+		if aok && bok {
+			return a < b
+		}
+		c, cok := keys[i].(int64)
+		d, dok := keys[j].(int64)
+		if cok && dok {
+			return c < d
+		}
+		return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+	})
+	return keys
+}
 
-	switch cur.Kind() {
-	case reflect.Slice:
-		fallthrough
-	case reflect.Array:
-		res := make([]interface{}, 0, cur.Len())
-		for i := 0; i < cur.Len(); i++ {
-			subField := fields
-			v, err := getSubFieldValue(subField, cur.Index(i))
-			if err != nil {
-				return nil, err
+// 按字段路径遍历节点, 路径中遇到数组时遍历数组中每个元素. fn 的参数为值在行中的路径和值.
+// 缺失的字段视为空值(空子消息, array中的空元素), 直接忽略
+func walkField(fields []string, node interface{}, path string, fn func(string, interface{}) error) error {
+	if p, ok := node.(*[]interface{}); ok {
+		node = *p
+	}
+	if arr, ok := node.([]interface{}); ok {
+		for i, sub := range arr {
+			if err := walkField(fields, sub, IndexPath(path, i), fn); err != nil {
+				return err
 			}
-			res = append(res, v)
 		}
-		return (interface{})(res), nil
-	case reflect.Map:
-		sfv := cur.MapIndex(reflect.ValueOf(fields[0]))
-		if !sfv.IsValid() {
-			return nil, fmt.Errorf("field %v not found", fields[0])
-		}
-		return sfv.Interface(), nil
-	case reflect.Struct:
-		sfv := reflect.Indirect(cur).FieldByName(fields[0])
-		if !sfv.IsValid() {
-			return nil, fmt.Errorf("field %v not found", fields[0])
+		return nil
+	}
+	if dict, ok := node.(Map); ok {
+		if len(fields) == 0 || (len(fields) == 1 && fields[0] == MapKeyField) {
+			keys := make([]string, 0, len(dict))
+			for k := range dict {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				var err error
+				if len(fields) == 0 {
+					err = walkField(fields, dict[k], FieldPath(path, k), fn)
+				} else {
+					err = fn(FieldPath(path, k), k)
+				}
+				if err != nil {
+					return err
+				}
+			}
+			return nil
 		}
-		return sfv.Interface(), nil
-	case reflect.Int32:
-		fallthrough
-	case reflect.Int64:
-		fallthrough
-	case reflect.String:
-		return cur.Interface(), nil
-	default:
-		return nil, fmt.Errorf("unhandle reflect type %v", cur.Kind())
+		node = map[string]interface{}(dict)
+	}
+	if len(fields) == 0 {
+		return fn(path, node)
+	}
+	m, ok := node.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("field %v not found", fields[0])
+	}
+	sub, ok := m[fields[0]]
+	if !ok {
+		return nil
 	}
+	return walkField(fields[1:], sub, FieldPath(path, fields[0]), fn)
 }
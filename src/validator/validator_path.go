@@ -0,0 +1,155 @@
+package validator
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 资源路径检查
+// path=Assets/Prefabs/*.prefab  * 替换为单元格的值, 检查资源根目录下文件是否存在
+// path=Assets/Prefabs  没有 * 时单元格的值拼接在目录后
+// path=Assets/Textures/*;ext=.png,.jpg  单元格的值必须以指定扩展名结尾
+// path=Assets/Textures/*;nocase  不检查大小写
+// 单元格的值可以是通配符, 比如 hero_*, 至少要匹配到一个文件
+type PathRule struct {
+}
+
+type pathRuleOption struct {
+	pattern string
+	exts    []string
+	nocase  bool
+}
+
+func init() {
+	Instance().RegisterHandler("path", &PathRule{})
+}
+
+func parsePathRule(dest string) (*pathRuleOption, error) {
+	opts := strings.Split(dest, ";")
+	opt := &pathRuleOption{pattern: strings.TrimSpace(opts[0])}
+	if opt.pattern == "" {
+		return nil, fmt.Errorf("path format error. example: Assets/Prefabs/*.prefab")
+	}
+	for _, o := range opts[1:] {
+		o = strings.TrimSpace(o)
+		switch {
+		case o == "nocase":
+			opt.nocase = true
+		case strings.HasPrefix(o, "ext="):
+			for _, ext := range strings.Split(o[len("ext="):], ",") {
+				if ext = strings.TrimSpace(ext); ext != "" {
+					opt.exts = append(opt.exts, ext)
+				}
+			}
+		default:
+			return nil, fmt.Errorf("path format error. unknown option %v", o)
+		}
+	}
+	return opt, nil
+}
+
+func (r *PathRule) CheckRuleFormat(src, cmd, dest string) error {
+	_, err := parsePathRule(dest)
+	return err
+}
+
+func (r *PathRule) VerifyRule(v *Validator, rule Rule) error {
+	opt, err := parsePathRule(rule.dst)
+	if err != nil {
+		return err
+	}
+	return v.verifyValues(rule, strings.Split(rule.src, "."), func(fv interface{}) error {
+		s, ok := fv.(string)
+		if !ok {
+			return errors.New("path only work on string field")
+		}
+		return verifyValue_Path(v.assetRoot, opt, s)
+	})
+}
+
+func verifyValue_Path(root string, opt *pathRuleOption, value string) error {
+	// 空值不做检查
+	if value == "" {
+		return nil
+	}
+	if strings.Contains(value, "\\") {
+		return fmt.Errorf("path %v should use / as separator", value)
+	}
+	if len(opt.exts) > 0 {
+		matched := false
+		for _, ext := range opt.exts {
+			if strings.HasSuffix(value, ext) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("path %v should end with %v", value, strings.Join(opt.exts, ","))
+		}
+	}
+	var rel string
+	if strings.Contains(opt.pattern, "*") {
+		rel = strings.ReplaceAll(opt.pattern, "*", value)
+	} else {
+		rel = opt.pattern + "/" + value
+	}
+	if !strings.ContainsAny(value, "*?[") {
+		return checkPath(root, rel, opt.nocase)
+	}
+	matches, err := filepath.Glob(filepath.Join(root, filepath.FromSlash(rel)))
+	if err != nil {
+		return fmt.Errorf("path %v invalid pattern. %v", rel, err)
+	}
+	if len(matches) == 0 {
+		return fmt.Errorf("path %v not match any file", rel)
+	}
+	if opt.nocase {
+		return nil
+	}
+	for _, m := range matches {
+		mrel, err := filepath.Rel(root, m)
+		if err != nil {
+			return err
+		}
+		if err := checkPath(root, filepath.ToSlash(mrel), false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 逐级检查路径存在, 并且大小写与磁盘上的文件一致, 防止windows下配置的路径在linux下找不到
+func checkPath(root string, rel string, nocase bool) error {
+	dir := root
+	for _, name := range strings.Split(rel, "/") {
+		if name == "" || name == "." || name == ".." {
+			dir = filepath.Join(dir, name)
+			continue
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return fmt.Errorf("path %v not exists", rel)
+		}
+		found := ""
+		for _, e := range entries {
+			if e.Name() == name {
+				found = name
+				break
+			}
+			if strings.EqualFold(e.Name(), name) {
+				found = e.Name()
+			}
+		}
+		if found == "" {
+			return fmt.Errorf("path %v not exists", rel)
+		}
+		if found != name && !nocase {
+			return fmt.Errorf("path %v case mismatch. %v on disk is %v", rel, name, found)
+		}
+		dir = filepath.Join(dir, found)
+	}
+	return nil
+}
@@ -14,58 +14,63 @@ func init() {
 	Instance().RegisterHandler("range", &RangeRule{})
 }
 
-func (r *RangeRule) CheckRuleFormat(src, cmd, dest string) error {
+// 范围边界, 整数边界按整数比较, 防止int64转float丢失精度
+type rangeBound struct {
+	min, max   float64
+	imin, imax int64
+	isInt      bool
+}
+
+func parseRange(dest string) (*rangeBound, error) {
 	if dest[0] != '[' || dest[len(dest)-1] != ']' {
-		return fmt.Errorf("range format error. example: [1,100] means range 1-100")
+		return nil, fmt.Errorf("range format error. example: [1,100] means range 1-100")
 	}
 	nArr := strings.Split(dest[1:len(dest)-1], ",")
 	if len(nArr) != 2 {
-		return fmt.Errorf("range format error. example: [1,100] means range 1-100")
+		return nil, fmt.Errorf("range format error. example: [1,100] means range 1-100")
 	}
-	for _, s := range nArr {
-		i, err := strconv.ParseInt(s, 10, 64)
+	b := &rangeBound{isInt: true}
+	for i, s := range nArr {
+		s = strings.TrimSpace(s)
+		f, err := strconv.ParseFloat(s, 64)
 		if err != nil {
-			return fmt.Errorf("range format error. %v is not valid number", s)
+			return nil, fmt.Errorf("range format error. %v is not valid number", s)
 		}
-		if i < 0 {
-			return fmt.Errorf("range format error. %v should not be negative", s)
+		if f < 0 {
+			return nil, fmt.Errorf("range format error. %v should not be negative", s)
 		}
-	}
-	return nil
-}
-
-func (r *RangeRule) VerifyRule(v *Validator, rule Rule) error {
-	fields := strings.Split(rule.src, ".")
-	table := v.tables[fields[0]]
-	if table == nil {
-		return errors.New("not found table data " + fields[0])
-	}
-	dst := strings.Split(rule.dst[1:len(rule.dst)-1], ",")
-	min, _ := strconv.ParseInt(dst[0], 10, 64)
-	max, _ := strconv.ParseInt(dst[1], 10, 64)
-	for _, row := range table {
-		fv, err := v.GetFieldValue(fields[1:], row)
+		n, err := strconv.ParseInt(s, 10, 64)
 		if err != nil {
-			return err
+			b.isInt = false
 		}
-		if arr, ok := fv.([]interface{}); ok {
-			for _, sfv := range arr {
-				if err := verifyValue_Range(sfv, min, max); err != nil {
-					return fmt.Errorf("table:%v id:%v ref fail. %v %v. err:%v", fields[0], row["Id"], rule.src, sfv, err)
-				}
-			}
+		if i == 0 {
+			b.min, b.imin = f, n
 		} else {
-			sfv := fv
-			if err := verifyValue_Range(sfv, min, max); err != nil {
-				return fmt.Errorf("table:%v id:%v ref fail. %v %v. err:%v", fields[0], row["Id"], rule.src, sfv, err)
-			}
+			b.max, b.imax = f, n
 		}
 	}
-	return nil
+	return b, nil
+}
+
+func (r *RangeRule) CheckRuleFormat(src, cmd, dest string) error {
+	_, err := parseRange(dest)
+	return err
 }
 
-func verifyValue_Range(fv interface{}, min int64, max int64) error {
+func (r *RangeRule) VerifyRule(v *Validator, rule Rule) error {
+	bound, err := parseRange(rule.dst)
+	if err != nil {
+		return err
+	}
+	return v.verifyValues(rule, strings.Split(rule.src, "."), func(fv interface{}) error {
+		return verifyValue_Range(fv, bound)
+	})
+}
+
+func verifyValue_Range(fv interface{}, b *rangeBound) error {
 	var v int64
+	var f float64
+	isInt := true
 	switch fv := fv.(type) {
 	case int:
 		v = int64(fv)
@@ -74,17 +79,41 @@ func verifyValue_Range(fv interface{}, min int64, max int64) error {
 	case int64:
 		v = int64(fv)
 	case float32:
-		v = int64(fv)
+		// float 列的值为 float32, 边界也舍入到 float32 后比较, 否则 0.1 会超出 [0,0.1]
+		if fv >= float32(b.min) && fv <= float32(b.max) {
+			return nil
+		}
+		return fmt.Errorf("value %v out of range [%v,%v]", fv, b.min, b.max)
 	case float64:
-		v = int64(fv)
+		f, isInt = fv, false
 
 	default:
 		return errors.New("range only work on signed number field")
 
 	}
-	if v >= min && v <= max {
-		return nil
+	if isInt && b.isInt {
+		if v >= b.imin && v <= b.imax {
+			return nil
+		}
 	} else {
-		return fmt.Errorf("value %v out of range [%v,%v]", fv, min, max)
+		if isInt {
+			f = float64(v)
+		}
+		if f >= b.min && f <= b.max {
+			return nil
+		}
+	}
+	return fmt.Errorf("value %v out of range [%v,%v]", fv, b.min, b.max)
+}
+
+// RangeBounds 规则 range=[1,100] 的边界, 整数边界为 int64, 否则为 float64
+func RangeBounds(dest string) (interface{}, interface{}, error) {
+	b, err := parseRange(dest)
+	if err != nil {
+		return nil, nil, err
+	}
+	if b.isInt {
+		return b.imin, b.imax, nil
 	}
+	return b.min, b.max, nil
 }
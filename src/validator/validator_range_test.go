@@ -0,0 +1,39 @@
+package validator
+
+import (
+	"math"
+	"testing"
+)
+
+func TestVerifyValueRange(t *testing.T) {
+	tests := []struct {
+		dest  string
+		value interface{}
+		ok    bool
+	}{
+		{"[1,100]", int32(1), true},
+		{"[1,100]", int32(100), true},
+		{"[1,100]", int32(0), false},
+		{"[1,100]", int64(101), false},
+		{"[0,9007199254740993]", int64(9007199254740993), true},
+		{"[0,9007199254740992]", int64(9007199254740993), false},
+		{"[0.5,1]", int32(0), false},
+		{"[0.5,1]", int32(1), true},
+		{"[0,0.1]", float32(0.1), true},
+		{"[0.1,1]", float32(0.1), true},
+		{"[0,0.1]", math.Nextafter32(0.1, 1), false},
+		{"[0.1,1]", math.Nextafter32(0.1, 0), false},
+		{"[0,0.1]", 0.1, true},
+		{"[0,0.1]", math.Nextafter(0.1, 1), false},
+		{"[0,1]", "1", false},
+	}
+	for _, tt := range tests {
+		b, err := parseRange(tt.dest)
+		if err != nil {
+			t.Fatalf("parseRange(%v): %v", tt.dest, err)
+		}
+		if err := verifyValue_Range(tt.value, b); (err == nil) != tt.ok {
+			t.Errorf("range=%v value %T(%v): got err %v, want ok %v", tt.dest, tt.value, tt.value, err, tt.ok)
+		}
+	}
+}
@@ -15,80 +15,92 @@ func init() {
 }
 
 func (r *RefRule) CheckRuleFormat(src, cmd, dest string) error {
+	if strings.Contains(dest, "(") {
+		if _, _, err := parseCompositeRef(dest); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// 多主键外键, 格式 SkillLevelConfig.(SkillId,Level)
+func parseCompositeRef(dest string) (string, []string, error) {
+	pos := strings.Index(dest, ".(")
+	if pos <= 0 || !strings.HasSuffix(dest, ")") {
+		return "", nil, fmt.Errorf("ref format error. example: SkillLevelConfig.(SkillId,Level)")
+	}
+	keys := strings.Split(dest[pos+2:len(dest)-1], ",")
+	for i := range keys {
+		keys[i] = strings.TrimSpace(keys[i])
+		if keys[i] == "" {
+			return "", nil, fmt.Errorf("ref format error. empty key field in %v", dest)
+		}
+	}
+	return dest[:pos], keys, nil
+}
+
 func (r *RefRule) VerifyRule(v *Validator, rule Rule) error {
 	fields := strings.Split(rule.src, ".")
-	table := v.tables[fields[0]]
-	if table == nil {
-		return errors.New("not found table data " + fields[0])
+	if strings.Contains(rule.dst, "(") {
+		return r.verifyCompositeRule(v, rule, fields)
 	}
 	dstFields := strings.Split(rule.dst, ".")
-	/*dstTable, ok := v.tables[dstFields[0]]
-	if !ok {
-		return errors.New("dst table not exist " + rule.dst)
-	}
-	*/
-	for _, row := range table {
-
-		fv, err := v.GetFieldValue(fields[1:], row)
-		if err != nil {
-			return err
+	// 目标列不存在或者不唯一时整条规则出错, 不在每一行重复报错
+	if refTable, ok := v.tables[dstFields[0]]; ok && len(dstFields) > 1 && !refTable.IsKey(dstFields[1:]) {
+		if _, _, err := refTable.FindValue(strings.Join(dstFields[1:], "."), nil); err != nil {
+			return fmt.Errorf("table:%v %v", dstFields[0], err)
 		}
-
-		if err := verifyField(v, dstFields, fields[2:], fv); err != nil {
-			return fmt.Errorf("table:%v id:%v ref fail. %v %v. err:%v", fields[0], row["Id"], rule.src, fv, err)
-		}
-		/*
-			rf := reflect.ValueOf(fv)
-			fmt.Println(rf.Kind(), fields[1:])
-			if arr, ok := fv.([]interface{}); ok {
-				for _, sfv := range arr {
-					if !keyExists(dstTable, dstFields[1:], sfv) {
-						return fmt.Errorf("table:%v id:%v ref fail. %v %v", fields[0], row["Id"], rule.src, sfv)
-					}
-				}
-			} else {
-				sfv := fv
-				if !keyExists(dstTable, dstFields[1:], sfv) {
-					return fmt.Errorf("table:%v id:%v ref fail. %v %v", fields[0], row["Id"], rule.src, sfv)
-				}
-			}
-		*/
-
 	}
-	return nil
+	return v.verifyValues(rule, fields, func(fv interface{}) error {
+		return verifyRefValue(v, dstFields, fv)
+	})
 }
 
-func verifyField(v *Validator, refFields []string, fields []string, fv interface{}) error {
-	rf := reflect.ValueOf(fv)
-	switch rf.Kind() {
-	case reflect.Array:
-		fallthrough
-	case reflect.Slice:
-		arr := fv.([]interface{})
-		for _, subfv := range arr {
-			if err := verifyField(v, refFields, fields[1:], subfv); err != nil {
-				return fmt.Errorf("%v ref %v fail. %v", fields, refFields, err)
+// 多主键外键: 从规则所在列的同级对象中取出同名字段, 拼接后在目标表中查找
+func (r *RefRule) verifyCompositeRule(v *Validator, rule Rule, fields []string) error {
+	refTableName, keyFields, err := parseCompositeRef(rule.dst)
+	if err != nil {
+		return err
+	}
+	refTable, ok := v.tables[refTableName]
+	if !ok {
+		return errors.New("ref table not exist " + refTableName)
+	}
+	return v.verifyValues(rule, fields[:len(fields)-1], func(node interface{}) error {
+		obj, ok := node.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%v is not a message", strings.Join(fields[1:len(fields)-1], "."))
+		}
+		values := make([]interface{}, 0, len(keyFields))
+		allZero := true
+		for _, k := range keyFields {
+			kv, ok := obj[k]
+			if !ok {
+				return fmt.Errorf("field %v not found", k)
+			}
+			if rv := reflect.ValueOf(kv); !rv.IsZero() {
+				allZero = false
 			}
+			values = append(values, kv)
 		}
-		return nil
-	case reflect.Ptr:
-		return verifyField(v, refFields, fields, rf.Elem().Interface())
-	case reflect.Struct:
-		sfv, err := getSubFieldValue(fields[0:], rf)
-		if err != nil {
-			return fmt.Errorf("get field %v fail. %v", fields[0], err)
+		// 全0 不做关联
+		if allZero {
+			return nil
 		}
-		return verifyField(v, refFields, fields[1:], sfv)
-	case reflect.Map:
-		sfv, err := getSubFieldValue(fields[0:], rf)
-		if err != nil {
-			return fmt.Errorf("get field %v fail. %v", fields[0], err)
+		key := JoinKey(values...)
+		if !refTable.IsKey(keyFields) {
+			return fmt.Errorf("(%v) is not the key of table %v", strings.Join(keyFields, ","), refTableName)
+		}
+		if _, ok := refTable.FindKey(key); !ok {
+			return fmt.Errorf("table:%v key:%v ref fail. key not exists", refTableName, key)
 		}
-		return verifyField(v, refFields, fields[1:], sfv)
+		return nil
+	})
+}
 
+func verifyRefValue(v *Validator, refFields []string, fv interface{}) error {
+	rf := reflect.ValueOf(fv)
+	switch rf.Kind() {
 	case reflect.Int32:
 		fallthrough
 	case reflect.Int64:
@@ -96,23 +108,34 @@ func verifyField(v *Validator, refFields []string, fields []string, fv interface
 		if rf.Int() == 0 {
 			return nil
 		}
+		fallthrough
+	case reflect.String:
+		// 空字符串 不做关联
+		if rf.Kind() == reflect.String && rf.String() == "" {
+			return nil
+		}
 		refTable, ok := v.tables[refFields[0]]
 		if !ok {
 			return errors.New("ref table not exist " + refFields[0])
 		}
-		if !keyExists(refTable, refFields[1:], fv) {
-			return fmt.Errorf("table:%v id:%v ref fail. key not exists", refFields[0], fv)
+		ok, err := keyExists(refTable, refFields[1:], fv)
+		if err != nil {
+			return fmt.Errorf("table:%v %v", refFields[0], err)
+		}
+		if !ok {
+			return fmt.Errorf("table:%v %v:%v ref fail. key not exists", refFields[0], strings.Join(refFields[1:], "."), fv)
 		}
 		return nil
 	}
-	return fmt.Errorf("verifyfail")
+	return fmt.Errorf("ref not support value %v", fv)
 }
 
-func keyExists(table map[interface{}]map[string]interface{}, fields []string, key interface{}) bool {
-	// TODO: 暂时只支持 id 外键
-	if _, ok := table[key]; ok {
-		return true
-	} else {
-		return false
+// 外键指向主键时按主键查找, 否则在目标列的索引中查找
+func keyExists(table *Table, fields []string, key interface{}) (bool, error) {
+	if len(fields) == 0 || table.IsKey(fields) {
+		_, ok := table.FindKey(key)
+		return ok, nil
 	}
+	_, ok, err := table.FindValue(strings.Join(fields, "."), key)
+	return ok, err
 }